@@ -0,0 +1,291 @@
+// Package bolt implements neoutils.NeoConnection over the Bolt protocol, for Neo4j versions without the REST API
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// IsBoltURL returns true if the URL should be connected to with Bolt rather than the REST API
+func IsBoltURL(neoURL string) bool {
+	for _, scheme := range []string{"bolt://", "bolt+s://", "bolt+ssc://", "neo4j://", "neo4j+s://", "neo4j+ssc://"} {
+		if strings.HasPrefix(neoURL, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures a Bolt connection
+type Config struct {
+	// Database is the name of the database to run queries against, or "" for the server's default database
+	Database string
+}
+
+// Connection runs Cypher queries over Bolt. Each CypherBatch runs in a single transaction, as with neoutils.
+//
+// With a neo4j:// URL the driver routes writes to the cluster leader and reads to followers and read replicas,
+// finding the new leader itself after a failover. Every session starts from the bookmark of the last write,
+// so reads from another member still see what this connection has written.
+type Connection struct {
+	driver   neo4j.Driver
+	auth     neo4j.AuthToken
+	uri      string
+	database string
+
+	mu       sync.Mutex
+	bookmark string
+}
+
+// Connect connects to the neo4j at neoURL, using the username and password in the URL if there are any
+func Connect(neoURL string, conf Config) (*Connection, error) {
+	u, err := url.Parse(neoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := neo4j.NoAuth()
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = neo4j.BasicAuth(u.User.Username(), password, "")
+		u.User = nil
+	}
+
+	driver, err := neo4j.NewDriver(u.String(), auth)
+	if err != nil {
+		return nil, err
+	}
+	if err := driver.VerifyConnectivity(); err != nil {
+		driver.Close()
+		return nil, err
+	}
+	return &Connection{driver: driver, auth: auth, uri: u.String(), database: conf.Database}, nil
+}
+
+// CypherBatch runs the queries in order in one write transaction, decoding the rows of each into its Result
+func (c *Connection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherBatchContext(context.Background(), queries)
+}
+
+// CypherReadBatch runs read-only queries as CypherBatch does, in a read transaction that a cluster
+// may serve from a follower or read replica
+func (c *Connection) CypherReadBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherReadBatchContext(context.Background(), queries)
+}
+
+// CypherBatchContext runs the queries as CypherBatch does, tracing each statement in a span under the one in ctx
+func (c *Connection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run(ctx, neo4j.AccessModeWrite, queries)
+}
+
+// CypherReadBatchContext runs the queries as CypherReadBatch does, tracing each statement in a span under the one in ctx
+func (c *Connection) CypherReadBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run(ctx, neo4j.AccessModeRead, queries)
+}
+
+func (c *Connection) run(ctx context.Context, mode neo4j.AccessMode, queries []*neoism.CypherQuery) error {
+	session := c.driver.NewSession(neo4j.SessionConfig{
+		AccessMode:   mode,
+		DatabaseName: c.database,
+		Bookmarks:    c.bookmarks(),
+	})
+	defer session.Close()
+
+	profile := profiler(ctx)
+	work := func(tx neo4j.Transaction) (interface{}, error) {
+		for _, q := range queries {
+			_, span := tracing.StartStatement(ctx, q)
+			err := runStatement(tx, q, profile)
+			tracing.End(span, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	// The driver retries transaction functions that fail because the leader has changed, once it has
+	// refreshed its routing table
+	if mode == neo4j.AccessModeRead {
+		_, err := session.ReadTransaction(work)
+		return err
+	}
+	if _, err := session.WriteTransaction(work); err != nil {
+		return err
+	}
+	c.setBookmark(session.LastBookmark())
+	return nil
+}
+
+// runStatement runs q, decoding its rows into q.Result. If profile is not nil the statement is run with PROFILE,
+// and its db hits passed to profile.
+func runStatement(tx neo4j.Transaction, q *neoism.CypherQuery, profile ProfileFunc) error {
+	statement := q.Statement
+	if profile != nil {
+		statement = "PROFILE " + statement
+	}
+	result, err := tx.Run(statement, parameters(q.Parameters))
+	if err != nil {
+		return err
+	}
+	records, err := result.Collect()
+	if err != nil {
+		return err
+	}
+	if q.Result != nil {
+		if err := decode(records, q.Result); err != nil {
+			return fmt.Errorf("could not decode the results of %q: %v", q.Statement, err)
+		}
+	}
+	if profile != nil {
+		summary, err := result.Consume()
+		if err != nil {
+			return err
+		}
+		if plan := summary.Profile(); plan != nil {
+			profile(q, dbHits(plan))
+		}
+	}
+	return nil
+}
+
+func (c *Connection) bookmarks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.bookmark == "" {
+		return nil
+	}
+	return []string{c.bookmark}
+}
+
+func (c *Connection) setBookmark(bookmark string) {
+	if bookmark == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bookmark = bookmark
+}
+
+// EnsureIndexes creates an index on each label and property, unless there is one already
+func (c *Connection) EnsureIndexes(indexes map[string]string) error {
+	for label, property := range indexes {
+		statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS FOR (n:%s) ON (n.%s)", label, property)
+		if err := c.CypherBatch([]*neoism.CypherQuery{{Statement: statement}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnsureConstraints makes each property unique for its label, unless it is already
+func (c *Connection) EnsureConstraints(constraints map[string]string) error {
+	for label, property := range constraints {
+		statement := fmt.Sprintf("CREATE CONSTRAINT IF NOT EXISTS ON (n:%s) ASSERT n.%s IS UNIQUE", label, property)
+		if err := c.CypherBatch([]*neoism.CypherQuery{{Statement: statement}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connections to neo4j
+func (c *Connection) Close() error {
+	return c.driver.Close()
+}
+
+func (c *Connection) String() string {
+	if c.database != "" {
+		return fmt.Sprintf("%s (database %s)", c.uri, c.database)
+	}
+	return c.uri
+}
+
+// parameters converts neoism parameters into the types the driver can send: it takes maps and slices of
+// interface{}, but not named map types like neoism.Props or typed slices like []string
+func parameters(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		converted[k] = parameter(v)
+	}
+	return converted
+}
+
+func parameter(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		for _, k := range rv.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = parameter(rv.MapIndex(k).Interface())
+		}
+		return m
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		s := make([]interface{}, rv.Len())
+		for i := range s {
+			s[i] = parameter(rv.Index(i).Interface())
+		}
+		return s
+	}
+	return v
+}
+
+// decode unmarshals the records into result through JSON, as neoism does with REST responses,
+// so the same result structs work with both
+func decode(records []*neo4j.Record, result interface{}) error {
+	rows := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		row := make(map[string]interface{}, len(record.Keys))
+		for j, key := range record.Keys {
+			row[key] = value(record.Values[j])
+		}
+		rows[i] = row
+	}
+
+	b, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
+
+// value converts nodes and relationships into their properties, as the REST API returns them
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case neo4j.Node:
+		return t.Props
+	case neo4j.Relationship:
+		return t.Props
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, e := range t {
+			s[i] = value(e)
+		}
+		return s
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[k] = value(e)
+		}
+		return m
+	}
+	return v
+}
@@ -0,0 +1,150 @@
+package bolt
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+const (
+	leader        = "LEADER"
+	defaultDBName = "neo4j"
+)
+
+// Member is a member of a Neo4j cluster, as listed by dbms.cluster.overview()
+type Member struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+	// Role is the member's role in Neo4j 3.5. Neo4j 4 lists its role in each database in Databases instead.
+	Role      string            `json:"role"`
+	Databases map[string]string `json:"databases"`
+}
+
+// roleIn returns the member's role in database, or in the default database if database is ""
+func (m Member) roleIn(database string) string {
+	if len(m.Databases) == 0 {
+		return m.Role
+	}
+	if database != "" {
+		return m.Databases[database]
+	}
+	if role, ok := m.Databases[defaultDBName]; ok {
+		return role
+	}
+	names := []string{}
+	for name := range m.Databases {
+		if name != "system" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return m.Databases[names[0]]
+}
+
+// boltAddress returns the host and port the member accepts Bolt connections on
+func (m Member) boltAddress() string {
+	for _, address := range m.Addresses {
+		if u, err := url.Parse(address); err == nil && u.Scheme == "bolt" {
+			return u.Host
+		}
+	}
+	return ""
+}
+
+// Members lists the members of the cluster the connection routes to
+func (c *Connection) Members() ([]Member, error) {
+	members := []Member{}
+	query := &neoism.CypherQuery{
+		Statement: `CALL dbms.cluster.overview()`,
+		Result:    &members,
+	}
+	if err := c.CypherReadBatch([]*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+type memberStatus struct {
+	address string
+	role    string
+	err     error
+}
+
+// CheckMembers connects directly to each member of the cluster, reporting its role in the database and
+// whether it is reachable. It fails if any member is unreachable or none of them leads the database.
+func (c *Connection) CheckMembers() (string, error) {
+	members, err := c.Members()
+	if err != nil {
+		if strings.Contains(err.Error(), "ProcedureNotFound") {
+			return fmt.Sprintf("%s is a single instance rather than a cluster", c.uri), nil
+		}
+		return "", err
+	}
+
+	statuses := make([]memberStatus, len(members))
+	for i, m := range members {
+		statuses[i] = memberStatus{address: m.boltAddress(), role: m.roleIn(c.database)}
+		statuses[i].err = c.checkMember(statuses[i].address)
+	}
+	return summarise(statuses, c.database)
+}
+
+func (c *Connection) checkMember(address string) error {
+	if address == "" {
+		return errors.New("no bolt address listed")
+	}
+	driver, err := neo4j.NewDriver(directURL(c.uri, address), c.auth)
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+	return driver.VerifyConnectivity()
+}
+
+// directURL returns a bolt URL for a single member at address, keeping the encryption settings of the
+// routing URL, so that neo4j+s:// becomes bolt+s://
+func directURL(routingURL string, address string) string {
+	scheme := "bolt"
+	if u, err := url.Parse(routingURL); err == nil {
+		if i := strings.Index(u.Scheme, "+"); i >= 0 {
+			scheme += u.Scheme[i:]
+		}
+	}
+	return scheme + "://" + address
+}
+
+func summarise(statuses []memberStatus, database string) (string, error) {
+	lines := make([]string, len(statuses))
+	unreachable := 0
+	hasLeader := false
+	for i, s := range statuses {
+		state := "ok"
+		if s.err != nil {
+			state = s.err.Error()
+			unreachable++
+		} else if s.role == leader {
+			hasLeader = true
+		}
+		lines[i] = fmt.Sprintf("%s %s: %s", s.address, s.role, state)
+	}
+	summary := strings.Join(lines, "; ")
+
+	if unreachable > 0 {
+		return summary, fmt.Errorf("%d of %d cluster members are unreachable: %s", unreachable, len(statuses), summary)
+	}
+	if !hasLeader {
+		if database == "" {
+			database = "the default database"
+		}
+		return summary, fmt.Errorf("no reachable cluster member leads %s: %s", database, summary)
+	}
+	return summary, nil
+}
@@ -0,0 +1,63 @@
+//go:build !jenkins
+// +build !jenkins
+
+package bolt
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConnection connects to the Neo4j at NEO4J_TEST_URL, skipping the test if that is not a Bolt URL
+func testConnection(t *testing.T) *Connection {
+	url := os.Getenv("NEO4J_TEST_URL")
+	if !IsBoltURL(url) {
+		t.Skip("NEO4J_TEST_URL is not a Bolt URL")
+	}
+	conn, err := Connect(url, Config{Database: os.Getenv("NEO4J_TEST_DATABASE")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestEnsureIndexesAndConstraintsCanRunAgain(t *testing.T) {
+	conn := testConnection(t)
+
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, conn.EnsureIndexes(map[string]string{"BoltTestThing": "name"}))
+		assert.NoError(t, conn.EnsureConstraints(map[string]string{"BoltTestThing": "uuid"}))
+	}
+}
+
+func TestCypherBatchPassesParametersAndDecodesResults(t *testing.T) {
+	assert := assert.New(t)
+	conn := testConnection(t)
+	defer conn.CypherBatch([]*neoism.CypherQuery{{Statement: `MATCH (n:BoltTestThing) DETACH DELETE n`}})
+
+	written := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	assert.NoError(conn.CypherBatch([]*neoism.CypherQuery{{
+		Statement:  `MERGE (n:BoltTestThing {uuid:$uuid}) SET n += $props RETURN n.uuid as uuid`,
+		Parameters: map[string]interface{}{"uuid": "a", "props": neoism.Props{"names": []string{"b", "c"}}},
+		Result:     &written,
+	}}))
+	assert.Len(written, 1)
+
+	read := []struct {
+		Names []string `json:"names"`
+	}{}
+	assert.NoError(conn.CypherReadBatch([]*neoism.CypherQuery{{
+		Statement:  `MATCH (n:BoltTestThing) WHERE n.uuid IN $uuids RETURN n.names as names`,
+		Parameters: map[string]interface{}{"uuids": []string{"a"}},
+		Result:     &read,
+	}}))
+	assert.Equal([]struct {
+		Names []string `json:"names"`
+	}{{[]string{"b", "c"}}}, read)
+}
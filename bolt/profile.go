@@ -0,0 +1,33 @@
+package bolt
+
+import (
+	"context"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/neo4j/neo4j-go-driver/v4/neo4j"
+)
+
+// ProfileFunc is passed each statement run with PROFILE and the number of db hits it took
+type ProfileFunc func(q *neoism.CypherQuery, dbHits int64)
+
+type profileKey struct{}
+
+// WithProfile has a Connection run the statements of a batch run with ctx under PROFILE, passing the db hits
+// of each to f. Statements that change the schema cannot be profiled.
+func WithProfile(ctx context.Context, f ProfileFunc) context.Context {
+	return context.WithValue(ctx, profileKey{}, f)
+}
+
+func profiler(ctx context.Context) ProfileFunc {
+	f, _ := ctx.Value(profileKey{}).(ProfileFunc)
+	return f
+}
+
+// dbHits adds up the db hits of every operator in plan
+func dbHits(plan neo4j.ProfiledPlan) int64 {
+	hits := plan.DbHits()
+	for _, child := range plan.Children() {
+		hits += dbHits(child)
+	}
+	return hits
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
+	log "github.com/sirupsen/logrus"
+)
+
+const maxImportLineBytes = 16 * 1024 * 1024
+
+// importer writes the things in a newline delimited JSON stream, such as an export, through a service.
+// Offsets are zero based line numbers in the stream.
+type importer struct {
+	service baseftrwapp.Service
+	workers int
+	// batchSize is the number of lines each worker writes together, if the service is a batchWriter
+	batchSize       int
+	checkpointFile  string
+	checkpointEvery int
+}
+
+// batchWriter is implemented by services that can write many things much more quickly than writing each in turn
+type batchWriter interface {
+	WriteMany(things []interface{}, transID string) error
+}
+
+type importRecord struct {
+	offset int
+	line   []byte
+}
+
+type importResult struct {
+	offset int
+	uuid   string
+	err    error
+}
+
+type importFailure struct {
+	Offset int    `json:"offset"`
+	UUID   string `json:"uuid,omitempty"`
+	Error  string `json:"error"`
+}
+
+// importSummary's NextOffset is the first line not yet written, where a resumed import starts, and LastOffset
+// the last line read
+type importSummary struct {
+	StartOffset int             `json:"startOffset"`
+	NextOffset  int             `json:"nextOffset"`
+	LastOffset  int             `json:"lastOffset"`
+	Written     int             `json:"written"`
+	Failures    []importFailure `json:"failures"`
+}
+
+// run imports every line of r at or after from, which may be gzip compressed
+func (imp importer) run(r io.Reader, from int) (importSummary, error) {
+	summary := importSummary{StartOffset: from, NextOffset: from, LastOffset: from - 1, Failures: []importFailure{}}
+
+	r, err := maybeGunzip(r)
+	if err != nil {
+		return summary, err
+	}
+
+	workers := imp.workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	batchSize := 1
+	if _, ok := imp.service.(batchWriter); ok && imp.batchSize > 1 {
+		batchSize = imp.batchSize
+	}
+
+	chunks := make(chan []importRecord)
+	results := make(chan importResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				for _, res := range imp.writeChunk(chunk) {
+					results <- res
+				}
+			}
+		}()
+	}
+
+	var scanErr error
+	go func() {
+		defer func() {
+			close(chunks)
+			wg.Wait()
+			close(results)
+		}()
+
+		chunk := make([]importRecord, 0, batchSize)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxImportLineBytes)
+		for offset := 0; scanner.Scan(); offset++ {
+			if offset < from {
+				continue
+			}
+			line := append([]byte(nil), scanner.Bytes()...)
+			if chunk = append(chunk, importRecord{offset, line}); len(chunk) == batchSize {
+				chunks <- chunk
+				chunk = make([]importRecord, 0, batchSize)
+			}
+		}
+		if len(chunk) > 0 {
+			chunks <- chunk
+		}
+		scanErr = scanner.Err()
+	}()
+
+	// Lines finish out of order, so the checkpoint is the first offset not yet written. It never passes a line
+	// that failed, so that resuming retries it, and lines after the first failure need not be remembered.
+	done := map[int]bool{}
+	firstFailure := -1
+	sinceCheckpoint := 0
+	for res := range results {
+		if res.offset > summary.LastOffset {
+			summary.LastOffset = res.offset
+		}
+		if res.err != nil {
+			log.WithError(res.err).WithFields(log.Fields{"offset": res.offset, "uuid": res.uuid}).Warn("Could not import line")
+			summary.Failures = append(summary.Failures, importFailure{res.offset, res.uuid, res.err.Error()})
+			if firstFailure < 0 || res.offset < firstFailure {
+				firstFailure = res.offset
+			}
+		} else if res.uuid != "" {
+			summary.Written++
+		}
+
+		if res.err == nil && (firstFailure < 0 || res.offset < firstFailure) {
+			done[res.offset] = true
+		}
+		for done[summary.NextOffset] {
+			delete(done, summary.NextOffset)
+			summary.NextOffset++
+		}
+
+		if sinceCheckpoint++; imp.checkpointEvery > 0 && sinceCheckpoint >= imp.checkpointEvery {
+			sinceCheckpoint = 0
+			if err := imp.saveCheckpoint(summary.NextOffset); err != nil {
+				log.WithError(err).Warn("Could not save the import checkpoint")
+			}
+		}
+	}
+
+	if err := imp.saveCheckpoint(summary.NextOffset); err != nil {
+		log.WithError(err).Warn("Could not save the import checkpoint")
+	}
+	return summary, scanErr
+}
+
+// writeChunk writes the lines of chunk together if the service is a batchWriter. If that fails it writes
+// them one at a time, so that only the lines that cannot be written are reported as failures.
+func (imp importer) writeChunk(chunk []importRecord) []importResult {
+	results := make([]importResult, 0, len(chunk))
+	bw, ok := imp.service.(batchWriter)
+	if !ok || len(chunk) == 1 {
+		for _, rec := range chunk {
+			results = append(results, imp.write(rec))
+		}
+		return results
+	}
+
+	things := []interface{}{}
+	decoded := []importResult{}
+	for _, rec := range chunk {
+		thing, res := imp.decode(rec)
+		if res.err != nil || res.uuid == "" {
+			results = append(results, res)
+			continue
+		}
+		things = append(things, thing)
+		decoded = append(decoded, res)
+	}
+	if len(things) == 0 {
+		return results
+	}
+
+	if err := bw.WriteMany(things, fmt.Sprintf("import_%d", chunk[0].offset)); err != nil {
+		log.WithError(err).WithFields(log.Fields{"offset": chunk[0].offset, "lines": len(chunk)}).Warn("Could not write the batch, writing its lines one at a time")
+		for i, res := range decoded {
+			decoded[i] = imp.writeOne(things[i], res)
+		}
+	}
+	return append(results, decoded...)
+}
+
+func (imp importer) write(rec importRecord) importResult {
+	thing, res := imp.decode(rec)
+	if res.err != nil || res.uuid == "" {
+		return res
+	}
+	return imp.writeOne(thing, res)
+}
+
+// decode returns the thing on the line. The result has no uuid if the line is blank, and an error if the
+// line cannot be imported.
+func (imp importer) decode(rec importRecord) (interface{}, importResult) {
+	if len(bytes.TrimSpace(rec.line)) == 0 {
+		return nil, importResult{offset: rec.offset}
+	}
+
+	thing, uuid, err := imp.service.DecodeJSON(json.NewDecoder(bytes.NewReader(rec.line)))
+	if err != nil {
+		return nil, importResult{rec.offset, uuid, fmt.Errorf("could not decode: %v", err)}
+	}
+	if uuid == "" {
+		return nil, importResult{rec.offset, uuid, fmt.Errorf("no uuid")}
+	}
+	return thing, importResult{rec.offset, uuid, nil}
+}
+
+func (imp importer) writeOne(thing interface{}, res importResult) importResult {
+	if err := imp.service.Write(thing, fmt.Sprintf("import_%d", res.offset)); err != nil {
+		res.err = fmt.Errorf("could not write: %v", err)
+	}
+	return res
+}
+
+// loadCheckpoint returns the offset saved in the checkpoint file, or 0 if there isn't one
+func (imp importer) loadCheckpoint() (int, error) {
+	if imp.checkpointFile == "" {
+		return 0, nil
+	}
+	b, err := ioutil.ReadFile(imp.checkpointFile)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func (imp importer) saveCheckpoint(offset int) error {
+	if imp.checkpointFile == "" {
+		return nil
+	}
+	tmp := imp.checkpointFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(offset)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, imp.checkpointFile)
+}
+
+// maybeGunzip decompresses r if it starts with the gzip magic number
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == io.EOF || (err == nil && (magic[0] != 0x1f || magic[1] != 0x8b)) {
+		return br, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gzip.NewReader(br)
+}
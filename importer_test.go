@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type thing struct {
+	UUID string `json:"uuid"`
+}
+
+type fakeService struct {
+	sync.Mutex
+	written []string
+}
+
+func (s *fakeService) Write(t interface{}, transID string) error {
+	s.Lock()
+	defer s.Unlock()
+	if t.(thing).UUID == "fail" {
+		return errors.New("neo4j is down")
+	}
+	s.written = append(s.written, t.(thing).UUID)
+	return nil
+}
+
+func (s *fakeService) Read(uuid string, transID string) (interface{}, bool, error) {
+	return nil, false, nil
+}
+func (s *fakeService) Delete(uuid string, transID string) (bool, error) { return false, nil }
+func (s *fakeService) Count() (int, error)                              { return 0, nil }
+func (s *fakeService) Check() error                                     { return nil }
+func (s *fakeService) Initialise() error                                { return nil }
+
+func (s *fakeService) DecodeJSON(dec *json.Decoder) (interface{}, string, error) {
+	t := thing{}
+	err := dec.Decode(&t)
+	return t, t.UUID, err
+}
+
+const importInput = `{"uuid":"a"}
+{"uuid":"b"}
+
+{"uuid":"fail"}
+not json
+{"uuid":"c"}
+`
+
+func TestImportWritesEveryLineAndReportsFailures(t *testing.T) {
+	s := &fakeService{}
+	summary, err := importer{service: s, workers: 3}.run(strings.NewReader(importInput), 0)
+
+	assert.NoError(t, err)
+	sort.Strings(s.written)
+	assert.Equal(t, []string{"a", "b", "c"}, s.written)
+	assert.Equal(t, 3, summary.Written)
+	assert.Equal(t, 3, summary.NextOffset, "The next offset stops at the first line that failed")
+	assert.Equal(t, 5, summary.LastOffset)
+	assert.Len(t, summary.Failures, 2)
+}
+
+func TestImportResumesFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	gz.Write([]byte(importInput))
+	gz.Close()
+
+	imp := importer{service: &fakeService{}, workers: 2, checkpointFile: filepath.Join(dir, "checkpoint"), checkpointEvery: 1}
+	assert.NoError(t, imp.saveCheckpoint(5))
+
+	from, err := imp.loadCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, from)
+
+	summary, err := imp.run(buf, from)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, imp.service.(*fakeService).written)
+	assert.Equal(t, 1, summary.Written)
+
+	next, err := imp.loadCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, 6, next)
+}
+
+func TestImportCheckpointStopsAtTheFirstFailedLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	imp := importer{service: &fakeService{}, workers: 3, checkpointFile: filepath.Join(dir, "checkpoint"), checkpointEvery: 1}
+	_, err = imp.run(strings.NewReader(importInput), 0)
+	assert.NoError(t, err)
+
+	from, err := imp.loadCheckpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, from, "Resuming retries the lines that failed")
+
+	imp.service = &fakeService{}
+	summary, err := imp.run(strings.NewReader(importInput), from)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, imp.service.(*fakeService).written)
+	assert.Len(t, summary.Failures, 2)
+}
+
+type fakeBatchService struct {
+	fakeService
+	batches [][]string
+}
+
+func (s *fakeBatchService) WriteMany(things []interface{}, transID string) error {
+	s.Lock()
+	defer s.Unlock()
+	batch := []string{}
+	for _, t := range things {
+		if t.(thing).UUID == "fail" {
+			return errors.New("neo4j is down")
+		}
+		batch = append(batch, t.(thing).UUID)
+	}
+	s.batches = append(s.batches, batch)
+	s.written = append(s.written, batch...)
+	return nil
+}
+
+func TestImportWritesBatchesAndRetriesFailedBatchesLineByLine(t *testing.T) {
+	s := &fakeBatchService{}
+	summary, err := importer{service: s, workers: 1, batchSize: 3}.run(strings.NewReader(importInput), 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"a", "b"}}, s.batches, "The second batch fails and is written line by line")
+	assert.Equal(t, []string{"a", "b", "c"}, s.written)
+	assert.Equal(t, 3, summary.Written)
+	assert.Equal(t, 3, summary.NextOffset, "The next offset stops at the first line that failed")
+	assert.Equal(t, 5, summary.LastOffset)
+	assert.Len(t, summary.Failures, 2)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// configureLogging sets the level logged from, e.g. debug or info, and the format, which is text or json
+func configureLogging(level string, format string) error {
+	l, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, must be text or json", format)
+	}
+	log.SetLevel(l)
+	return nil
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// logLevelHandler responds with the level logged from, and changes it on a PUT of e.g. {"level": "debug"},
+// until the service restarts
+func logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		req := logLevel{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeLogLevelError(w, "body must be a JSON object with a level")
+			return
+		}
+		l, err := log.ParseLevel(req.Level)
+		if err != nil {
+			writeLogLevelError(w, err.Error())
+			return
+		}
+		previous := log.GetLevel()
+		log.SetLevel(l)
+		log.WithFields(log.Fields{"previous_level": previous.String(), "level": l.String()}).Warn("Log level changed")
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(logLevel{log.GetLevel().String()})
+}
+
+func writeLogLevelError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
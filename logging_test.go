@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer configureLogging("info", "text")
+	assert := assert.New(t)
+
+	assert.NoError(configureLogging("debug", "json"))
+	assert.Equal(log.DebugLevel, log.GetLevel())
+	assert.IsType(&log.JSONFormatter{}, log.StandardLogger().Formatter)
+
+	assert.Error(configureLogging("chatty", "text"))
+	assert.Error(configureLogging("info", "xml"))
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	defer log.SetLevel(log.InfoLevel)
+	assert := assert.New(t)
+	log.SetLevel(log.InfoLevel)
+
+	w := httptest.NewRecorder()
+	logLevelHandler(w, httptest.NewRequest("PUT", "/__log-level", strings.NewReader(`{"level":"debug"}`)))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"level":"debug"}`, w.Body.String())
+	assert.Equal(log.DebugLevel, log.GetLevel())
+
+	w = httptest.NewRecorder()
+	logLevelHandler(w, httptest.NewRequest("PUT", "/__log-level", strings.NewReader(`{"level":"chatty"}`)))
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Equal(log.DebugLevel, log.GetLevel(), "Unchanged by a bad level")
+
+	w = httptest.NewRecorder()
+	logLevelHandler(w, httptest.NewRequest("GET", "/__log-level", nil))
+	assert.JSONEq(`{"level":"debug"}`, w.Body.String())
+}
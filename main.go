@@ -1,18 +1,34 @@
+// The routes use the method and wildcard patterns of Go 1.22's ServeMux. Built in GOPATH mode, as the Dockerfile
+// and CI do, the service would otherwise get the Go 1.21 mux, which matches them as literal paths.
+//go:debug httpmuxgo121=0
+
 package main
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	_ "net/http/pprof"
 	"os"
-
 	"time"
 
 	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/Financial-Times/http-handlers-go/httphandlers"
+	"github.com/Financial-Times/memberships-rw-neo4j/bolt"
 	"github.com/Financial-Times/memberships-rw-neo4j/memberships"
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
+	status "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	gometrics "github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
@@ -20,9 +36,15 @@ func main() {
 	neoURL := app.String(cli.StringOpt{
 		Name:   "neo-url",
 		Value:  "http://localhost:7474/db/data",
-		Desc:   "neo4j endpoint URL",
+		Desc:   "neo4j endpoint URL. The REST API is used for http:// URLs, and Bolt for bolt:// and neo4j:// URLs, which may include a username and password",
 		EnvVar: "NEO_URL",
 	})
+	neoDatabase := app.String(cli.StringOpt{
+		Name:   "neo-database",
+		Value:  "",
+		Desc:   "Name of the neo4j database to use. Leave empty for the server's default database. Needs a Bolt neo-url",
+		EnvVar: "NEO_DATABASE",
+	})
 	graphiteTCPAddress := app.String(cli.StringOpt{
 		Name:   "graphiteTCPAddress",
 		Value:  "",
@@ -44,7 +66,7 @@ func main() {
 	batchSize := app.Int(cli.IntOpt{
 		Name:   "batchSize",
 		Value:  1024,
-		Desc:   "Maximum number of statements to execute per batch",
+		Desc:   "Maximum number of statements to execute per batch, and the number of memberships the import writes together",
 		EnvVar: "BATCH_SIZE",
 	})
 	logMetrics := app.Bool(cli.BoolOpt{
@@ -53,23 +75,463 @@ func main() {
 		Desc:   "Whether to log metrics. Set to true if running locally and you want metrics output",
 		EnvVar: "LOG_METRICS",
 	})
+	logLevelName := app.String(cli.StringOpt{
+		Name:   "log-level",
+		Value:  "info",
+		Desc:   "Level to log from: debug, info, warning, error, fatal or panic. It can be changed while running with a PUT to /__log-level",
+		EnvVar: "LOG_LEVEL",
+	})
+	logFormat := app.String(cli.StringOpt{
+		Name:   "log-format",
+		Value:  "text",
+		Desc:   "Format of the log lines, text or json",
+		EnvVar: "LOG_FORMAT",
+	})
+	prometheusMetrics := app.Bool(cli.BoolOpt{
+		Name:   "prometheus",
+		Value:  true,
+		Desc:   "Whether to serve Prometheus metrics at /metrics. This is independent of the graphite output, so either, both or neither can be used",
+		EnvVar: "PROMETHEUS",
+	})
+	otlpEndpoint := app.String(cli.StringOpt{
+		Name:   "otlpEndpoint",
+		Value:  "",
+		Desc:   "URL of an OpenTelemetry collector to send traces to over OTLP/HTTP, e.g. http://localhost:4318. Requests are not traced if empty",
+		EnvVar: "OTEL_EXPORTER_OTLP_ENDPOINT",
+	})
+	slowQueryThresholdMs := app.Int(cli.IntOpt{
+		Name:   "slowQueryThresholdMs",
+		Value:  1000,
+		Desc:   "Milliseconds a batch of Cypher statements may take before its statements are logged, with their parameter values redacted. 0 logs none",
+		EnvVar: "SLOW_QUERY_THRESHOLD_MS",
+	})
+	profileEvery := app.Int(cli.IntOpt{
+		Name:   "profileEvery",
+		Value:  0,
+		Desc:   "Run one in every this many batches of Cypher statements with PROFILE, logging the db hits of each statement. 0 profiles none. Needs a Bolt neo-url",
+		EnvVar: "PROFILE_EVERY",
+	})
+	healthLatencyThresholdMs := app.Int(cli.IntOpt{
+		Name:   "healthLatencyThresholdMs",
+		Value:  2000,
+		Desc:   "Milliseconds a query may take before the latency health check fails",
+		EnvVar: "HEALTH_LATENCY_THRESHOLD_MS",
+	})
+	countDropThresholdPercent := app.Int(cli.IntOpt{
+		Name:   "countDropThresholdPercent",
+		Value:  10,
+		Desc:   "Percentage drop in the membership count, since the last healthy check, at which the count health check fails",
+		EnvVar: "COUNT_DROP_THRESHOLD_PERCENT",
+	})
+	countBaselineWindowMs := app.Int(cli.IntOpt{
+		Name:   "countBaselineWindowMs",
+		Value:  24 * 60 * 60 * 1000,
+		Desc:   "Milliseconds the count health check fails for after a drop, before it takes the lower count as its baseline. 0 keeps failing until the count recovers or the service restarts",
+		EnvVar: "COUNT_BASELINE_WINDOW_MS",
+	})
+	storeType := app.String(cli.StringOpt{
+		Name:   "store",
+		Value:  "neo4j",
+		Desc:   "Where to keep memberships: neo4j, or memory for local development and testing",
+		EnvVar: "STORE",
+	})
+	startupAttempts := app.Int(cli.IntOpt{
+		Name:   "startupAttempts",
+		Value:  10,
+		Desc:   "Number of times to try connecting to neo4j and creating the schema before exiting",
+		EnvVar: "STARTUP_ATTEMPTS",
+	})
+	startupBackoffMs := app.Int(cli.IntOpt{
+		Name:   "startupBackoffMs",
+		Value:  1000,
+		Desc:   "Milliseconds to wait before the first startup retry, doubling for each retry after that",
+		EnvVar: "STARTUP_BACKOFF_MS",
+	})
+	startupMaxBackoffMs := app.Int(cli.IntOpt{
+		Name:   "startupMaxBackoffMs",
+		Value:  30000,
+		Desc:   "Maximum milliseconds to wait between startup retries",
+		EnvVar: "STARTUP_MAX_BACKOFF_MS",
+	})
+	cacheSize := app.Int(cli.IntOpt{
+		Name:   "cacheSize",
+		Value:  0,
+		Desc:   "Number of memberships to cache in memory for reads. 0 turns the cache off",
+		EnvVar: "CACHE_SIZE",
+	})
+	cacheTTLMs := app.Int(cli.IntOpt{
+		Name:   "cacheTtlMs",
+		Value:  60000,
+		Desc:   "Milliseconds a cached membership is served for before it is read from the store again",
+		EnvVar: "CACHE_TTL_MS",
+	})
+	cachePeers := app.Strings(cli.StringsOpt{
+		Name:   "cachePeers",
+		Value:  []string{},
+		Desc:   "Base URLs of the other instances, e.g. http://memberships-rw-neo4j-2:8080, told to drop a membership from their caches when this instance writes or deletes it",
+		EnvVar: "CACHE_PEERS",
+	})
+	sourcePriority := app.Strings(cli.StringsOpt{
+		Name:   "sourcePriority",
+		Value:  []string{},
+		Desc:   "Source authorities from the highest priority to the lowest, e.g. MANUAL,FACTSET. A write from a source may not overwrite a membership written by a higher priority one. Empty allows any write",
+		EnvVar: "SOURCE_PRIORITY",
+	})
 	env := app.String(cli.StringOpt{
 		Name:  "env",
 		Value: "local",
 		Desc:  "environment this app is running in",
 	})
 
+	app.Command("integrity", "Report memberships that break the invariants of the graph model", func(cmd *cli.Cmd) {
+		limit := cmd.Int(cli.IntOpt{
+			Name:  "limit",
+			Value: 100,
+			Desc:  "Maximum number of violations to list per check",
+		})
+
+		cmd.Action = func() {
+			db, err := connect(*neoURL, *neoDatabase, *batchSize)
+			if err != nil {
+				log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+			}
+
+			report, err := memberships.NewCypherStore(db).CheckIntegrity(context.Background(), *limit)
+			if err != nil {
+				log.Fatalf("Could not check membership integrity, error=[%s]\n", err)
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+
+			if !report.Healthy {
+				cli.Exit(1)
+			}
+		}
+	})
+
+	app.Command("recompute-epochs", "Set the epoch property of each membership and role date to match the date", func(cmd *cli.Cmd) {
+		epochBatchSize := cmd.Int(cli.IntOpt{
+			Name:  "batch-size",
+			Value: memberships.DefaultEpochBatchSize,
+			Desc:  "Number of memberships to read and fix at a time",
+		})
+
+		cmd.Action = func() {
+			db, err := connect(*neoURL, *neoDatabase, *batchSize)
+			if err != nil {
+				log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+			}
+
+			report, err := memberships.NewCypherStore(db).RecomputeEpochs(context.Background(), *epochBatchSize, nil)
+			if err != nil {
+				log.Fatalf("Could not recompute epochs after %d memberships, error=[%s]\n", report.MembershipsScanned, err)
+			}
+
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+
+			if report.UnparseableCount > 0 {
+				cli.Exit(1)
+			}
+		}
+	})
+
+	app.Command("migrate", "Apply the pending schema migrations to neo4j", func(cmd *cli.Cmd) {
+		dryRun := cmd.Bool(cli.BoolOpt{
+			Name:  "dry-run",
+			Value: false,
+			Desc:  "Print the Cypher of the pending migrations without applying them",
+		})
+
+		cmd.Action = func() {
+			// Without a batch size, so that each schema statement is sent in a transaction of its own
+			db, err := connect(*neoURL, *neoDatabase, 0)
+			if err != nil {
+				log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+			}
+			store := memberships.NewCypherStore(db)
+
+			if *dryRun {
+				pending, err := store.PendingMigrations()
+				if err != nil {
+					log.Fatalf("Could not find the pending migrations, error=[%s]\n", err)
+				}
+				for _, m := range pending {
+					fmt.Printf("// %d: %s\n", m.Version, m.Description)
+					for _, statement := range m.Statements {
+						fmt.Printf("%s;\n", statement)
+					}
+				}
+				return
+			}
+
+			applied, err := store.Migrate()
+			if err != nil {
+				log.Fatalf("Could not migrate the schema after applying %d migrations, error=[%s]\n", applied, err)
+			}
+			log.Infof("Applied %d migrations", applied)
+		}
+	})
+
+	app.Command("export", "Export every membership as newline delimited JSON", func(cmd *cli.Cmd) {
+		output := cmd.String(cli.StringOpt{
+			Name:  "output",
+			Value: "-",
+			Desc:  "File to write the export to, - for stdout",
+		})
+		compress := cmd.Bool(cli.BoolOpt{
+			Name:  "gzip",
+			Value: false,
+			Desc:  "Gzip compress the export",
+		})
+		since := cmd.String(cli.StringOpt{
+			Name:  "since",
+			Value: "",
+			Desc:  "Only export memberships last modified at or after this RFC3339 timestamp",
+		})
+		pageSize := cmd.Int(cli.IntOpt{
+			Name:  "page-size",
+			Value: memberships.DefaultExportPageSize,
+			Desc:  "Number of memberships to read from neo4j at a time",
+		})
+
+		cmd.Action = func() {
+			var sinceTime time.Time
+			if *since != "" {
+				var err error
+				if sinceTime, err = time.Parse(time.RFC3339, *since); err != nil {
+					log.Fatalf("Invalid since timestamp, error=[%s]\n", err)
+				}
+			}
+
+			db, err := connect(*neoURL, *neoDatabase, *batchSize)
+			if err != nil {
+				log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+			}
+
+			var out io.WriteCloser = os.Stdout
+			if *output != "-" {
+				if out, err = os.Create(*output); err != nil {
+					log.Fatalf("Could not create the export file, error=[%s]\n", err)
+				}
+			}
+			defer out.Close()
+
+			var w io.Writer = out
+			if *compress {
+				gz := gzip.NewWriter(out)
+				defer gz.Close()
+				w = gz
+			}
+
+			count, err := memberships.NewCypherMembershipService(db).Export(context.Background(), w, sinceTime, *pageSize)
+			if err != nil {
+				log.Fatalf("Export failed after %d memberships, error=[%s]\n", count, err)
+			}
+			log.Infof("Exported %d memberships", count)
+		}
+	})
+
+	app.Command("import", "Write the memberships in a newline delimited JSON file, such as an export, to neo4j", func(cmd *cli.Cmd) {
+		input := cmd.String(cli.StringOpt{
+			Name:  "input",
+			Value: "-",
+			Desc:  "File to import, - for stdin. Gzip compressed files are detected",
+		})
+		workers := cmd.Int(cli.IntOpt{
+			Name:  "workers",
+			Value: 4,
+			Desc:  "Number of batches of memberships to write in parallel. Each batch has batchSize memberships",
+		})
+		fromOffset := cmd.Int(cli.IntOpt{
+			Name:  "from-offset",
+			Value: -1,
+			Desc:  "Line to start importing from, counting from 0. Defaults to the offset in the checkpoint file, if any",
+		})
+		checkpoint := cmd.String(cli.StringOpt{
+			Name:  "checkpoint",
+			Value: "",
+			Desc:  "File to save the next line to import in, so that an interrupted import can be resumed",
+		})
+		checkpointEvery := cmd.Int(cli.IntOpt{
+			Name:  "checkpoint-every",
+			Value: 100,
+			Desc:  "Number of lines to import between checkpoints",
+		})
+
+		cmd.Action = func() {
+			var in io.ReadCloser = os.Stdin
+			var err error
+			if *input != "-" {
+				if in, err = os.Open(*input); err != nil {
+					log.Fatalf("Could not open the import file, error=[%s]\n", err)
+				}
+			}
+			defer in.Close()
+
+			db, err := connect(*neoURL, *neoDatabase, *batchSize)
+			if err != nil {
+				log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+			}
+			cypherStore := memberships.NewCypherStore(db)
+			// A batch that breaks the source policy is written a membership at a time, through guarded writes
+			if !bolt.IsBoltURL(*neoURL) && *batchSize > 0 {
+				direct, err := connect(*neoURL, *neoDatabase, 0)
+				if err != nil {
+					log.Fatalf("Could not connect to neo4j, error=[%s]\n", err)
+				}
+				cypherStore = cypherStore.WithDirectConnection(direct)
+			}
+			var store memberships.MembershipStore = cypherStore
+			if len(*sourcePriority) > 0 {
+				store = memberships.EnforceSourcePolicy(store, memberships.SourcePolicy{Priority: *sourcePriority})
+			}
+			membershipsDriver := memberships.NewMembershipService(store)
+			if err := membershipsDriver.Initialise(); err != nil {
+				log.Fatalf("Could not create the neo4j schema, error=[%s]\n", err)
+			}
+
+			imp := importer{
+				service:         membershipsDriver,
+				workers:         *workers,
+				batchSize:       *batchSize,
+				checkpointFile:  *checkpoint,
+				checkpointEvery: *checkpointEvery,
+			}
+
+			from := *fromOffset
+			if from < 0 {
+				if from, err = imp.loadCheckpoint(); err != nil {
+					log.Fatalf("Could not read the import checkpoint, error=[%s]\n", err)
+				}
+			}
+
+			summary, err := imp.run(in, from)
+			fmt.Printf("Imported lines %d to %d: %d memberships written, %d failed, resume from line %d\n",
+				summary.StartOffset, summary.LastOffset, summary.Written, len(summary.Failures), summary.NextOffset)
+			for _, f := range summary.Failures {
+				fmt.Printf("  line %d uuid=%s: %s\n", f.Offset, f.UUID, f.Error)
+			}
+			if err != nil {
+				log.Fatalf("Could not read the import file, error=[%s]\n", err)
+			}
+			if len(summary.Failures) > 0 {
+				cli.Exit(1)
+			}
+		}
+	})
+
 	app.Action = func() {
-		conf := neoutils.DefaultConnectionConfig()
-		conf.BatchSize = *batchSize
-		db, err := neoutils.Connect(*neoURL, conf)
+		var st startup
+		b := backoff{
+			attempts: *startupAttempts,
+			initial:  time.Duration(*startupBackoffMs) * time.Millisecond,
+			max:      time.Duration(*startupMaxBackoffMs) * time.Millisecond,
+		}
+		fail := func(err error) {
+			log.Fatalf("Could not start up, error=[%s]\n", err)
+		}
+
+		if err := tracing.Configure(context.Background(), *otlpEndpoint, "memberships-rw-neo4j"); err != nil {
+			log.Fatalf("Could not set up tracing, error=[%s]\n", err)
+		}
+
+		var checks []fthealth.Check
+		var store memberships.MembershipStore
+
+		var metrics *memberships.Metrics
+		if *prometheusMetrics {
+			metrics = memberships.NewMetrics(prometheus.DefaultRegisterer)
+		}
 
-		if err != nil {
-			log.Errorf("Could not connect to neo4j, error=[%s]\n", err)
+		switch *storeType {
+		case "neo4j":
+			if *profileEvery > 0 && !bolt.IsBoltURL(*neoURL) {
+				log.Warn("Cypher statements can only be profiled over Bolt, none will be")
+			}
+			// Over REST, with a batch size, the shared connection merges the batches of concurrent callers
+			merged := !bolt.IsBoltURL(*neoURL) && *batchSize > 0
+			instrument := func(conn neoutils.NeoConnection, merged bool) neoutils.NeoConnection {
+				if metrics != nil {
+					conn = metrics.InstrumentConnection(conn, merged)
+				}
+				if *slowQueryThresholdMs > 0 || *profileEvery > 0 {
+					conn = memberships.LogQueries(conn, memberships.QueryLogConfig{
+						SlowThreshold: time.Duration(*slowQueryThresholdMs) * time.Millisecond,
+						ProfileEvery:  *profileEvery,
+						Merged:        merged,
+					})
+				}
+				return conn
+			}
+			db := &pendingConnection{neoURL: *neoURL}
+			// Guarded writes need a transaction of their own, which the REST connection's batching does not give them
+			direct := &pendingConnection{neoURL: *neoURL}
+			cypherStore := memberships.NewCypherStore(instrument(db, merged)).WithDirectConnection(instrument(direct, false))
+			store = cypherStore
+
+			go st.run(func() error {
+				conn, err := connect(*neoURL, *neoDatabase, *batchSize)
+				if err != nil {
+					return err
+				}
+				unbatched := conn
+				if !bolt.IsBoltURL(*neoURL) && *batchSize > 0 {
+					if unbatched, err = connect(*neoURL, *neoDatabase, 0); err != nil {
+						return err
+					}
+				}
+				db.set(conn)
+				direct.set(unbatched)
+				return nil
+			}, cypherStore.Initialise, b, fail)
+
+			http.HandleFunc("/__integrity", cypherStore.IntegrityHandler)
+			epochJob := memberships.NewEpochJob(cypherStore, memberships.DefaultEpochBatchSize)
+			http.HandleFunc("GET /__admin/recompute-epochs", epochJob.Handler)
+			http.HandleFunc("POST /__admin/recompute-epochs", epochJob.Handler)
+
+			healthChecker := memberships.NewHealthChecker(cypherStore, time.Duration(*healthLatencyThresholdMs)*time.Millisecond, *countDropThresholdPercent, time.Duration(*countBaselineWindowMs)*time.Millisecond)
+			checks = append(checks, makeCheck(cypherStore, db))
+			checks = append(checks, makeDataChecks(healthChecker, db)...)
+			if bolt.IsBoltURL(*neoURL) {
+				checks = append(checks, makeClusterCheck(db))
+			}
+		case "memory":
+			log.Warn("Keeping memberships in memory, they will be lost when the service stops")
+			store = memberships.NewMemoryStore()
+			st.run(func() error { return nil }, store.Initialise, b, fail)
+		default:
+			log.Fatalf("Unknown store %q, must be neo4j or memory\n", *storeType)
 		}
 
-		membershipsDriver := memberships.NewCypherMembershipService(db)
-		membershipsDriver.Initialise()
+		if len(*sourcePriority) > 0 {
+			store = memberships.EnforceSourcePolicy(store, memberships.SourcePolicy{Priority: *sourcePriority})
+		}
+
+		if *cacheSize > 0 {
+			cache, err := memberships.NewCachingStore(store, memberships.CacheConfig{
+				Size:  *cacheSize,
+				TTL:   time.Duration(*cacheTTLMs) * time.Millisecond,
+				Peers: *cachePeers,
+			})
+			if err != nil {
+				log.Fatalf("Could not create the cache, error=[%s]\n", err)
+			}
+			store = cache
+			http.HandleFunc("POST "+memberships.InvalidatePath, cache.InvalidateHandler)
+		}
+
+		if metrics != nil {
+			memberships.RegisterCountGauge(prometheus.DefaultRegisterer, store)
+			store = metrics.InstrumentStore(store)
+			http.Handle("/metrics", promhttp.Handler())
+		}
+
+		membershipsDriver := memberships.NewMembershipService(store)
 
 		baseftrwapp.OutputMetricsIfRequired(*graphiteTCPAddress, *graphitePrefix, *logMetrics)
 
@@ -77,10 +539,22 @@ func main() {
 			"memberships": membershipsDriver,
 		}
 
-		var checks []fthealth.Check
-		for _, service := range services {
-			checks = append(checks, makeCheck(service, db))
-		}
+		http.HandleFunc("GET "+memberships.SchemaPath, memberships.SchemaHandler)
+		http.HandleFunc("GET "+memberships.APIPath, memberships.APIHandler)
+		http.HandleFunc("GET /__log-level", logLevelHandler)
+		http.HandleFunc("PUT /__log-level", logLevelHandler)
+		http.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(st.gtg(membershipsDriver.Check)))
+		// These take precedence over the routes baseftrwapp serves, so that requests are traced through to neo4j
+		http.Handle("GET /memberships/{uuid}", traced("read membership", membershipsDriver.ReadHandler))
+		http.Handle("PUT /memberships/{uuid}", traced("write membership", membershipsDriver.WriteHandler))
+		http.Handle("DELETE /memberships/{uuid}", traced("delete membership", membershipsDriver.DeleteHandler))
+		http.Handle("PATCH /memberships/{uuid}", traced("patch membership", membershipsDriver.PatchHandler))
+		http.Handle("GET /memberships/{uuid}/roles/{roleUuid}", traced("read role", membershipsDriver.ReadRoleHandler))
+		http.Handle("PUT /memberships/{uuid}/roles/{roleUuid}", traced("write role", membershipsDriver.WriteRoleHandler))
+		http.Handle("DELETE /memberships/{uuid}/roles/{roleUuid}", traced("delete role", membershipsDriver.DeleteRoleHandler))
+		http.Handle("GET /memberships/__count", traced("count memberships", membershipsDriver.CountHandler))
+		http.Handle("GET /memberships/__export", traced("export memberships", membershipsDriver.ExportHandler))
+		http.Handle("POST /memberships/__batch-read", traced("batch read memberships", membershipsDriver.BatchReadHandler))
 
 		timedHC := fthealth.TimedHealthCheck{
 			HealthCheck: fthealth.HealthCheck{
@@ -102,18 +576,99 @@ func main() {
 		})
 	}
 
-	log.SetLevel(log.InfoLevel)
-	log.Infof("Application started with args %v", os.Args)
+	app.Before = func() {
+		if err := configureLogging(*logLevelName, *logFormat); err != nil {
+			log.Fatalf("Could not configure logging, error=[%s]\n", err)
+		}
+		log.Infof("Application started with args %v", os.Args)
+	}
 	app.Run(os.Args)
 }
 
-func makeCheck(service baseftrwapp.Service, cr neoutils.CypherRunner) fthealth.Check {
+// traced serves h with the request logging and metrics baseftrwapp gives its own routes, in a span that continues
+// any trace in the request headers
+func traced(operation string, h http.HandlerFunc) http.Handler {
+	logged := httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), h)
+	return otelhttp.NewHandler(httphandlers.HTTPMetricsHandler(gometrics.DefaultRegistry, logged), operation)
+}
+
+func connect(neoURL string, database string, batchSize int) (neoutils.NeoConnection, error) {
+	if bolt.IsBoltURL(neoURL) {
+		return bolt.Connect(neoURL, bolt.Config{Database: database})
+	}
+	if database != "" {
+		return nil, fmt.Errorf("cannot use database %q over the REST API, use a bolt:// or neo4j:// neo-url", database)
+	}
+	conf := neoutils.DefaultConnectionConfig()
+	conf.BatchSize = batchSize
+	return neoutils.Connect(neoURL, conf)
+}
+
+func makeCheck(store memberships.MembershipStore, cr neoutils.CypherRunner) fthealth.Check {
 	return fthealth.Check{
 		BusinessImpact:   "Cannot read/write memberships via this writer",
 		Name:             "Check connectivity to Neo4j - neoUrl is a parameter in hieradata for this service",
-		PanicGuide:       "TODO - write panic guide",
+		PanicGuide:       "Check that the Neo4j instance at the configured neo-url is up and reachable from this pod, then restart the service if it does not recover on its own",
 		Severity:         1,
-		TechnicalSummary: fmt.Sprintf("Cannot connect to Neo4j instance %s with at least one person loaded in it", cr),
-		Checker:          func() (string, error) { return "", service.Check() },
+		TechnicalSummary: fmt.Sprintf("Cannot run a query against the Neo4j instance %s", cr),
+		Checker:          func() (string, error) { return "", store.Check() },
+	}
+}
+
+func makeDataChecks(hc *memberships.HealthChecker, cr neoutils.CypherRunner) []fthealth.Check {
+	return []fthealth.Check{
+		{
+			BusinessImpact:   "Duplicate memberships or identifiers may be written, which consumers would see as conflicting data",
+			Name:             "Check that the Neo4j indexes and constraints for memberships are present",
+			PanicGuide:       "Restart the service so that it recreates the schema on startup. If the check still fails, run the integrity report at /__integrity, remove any duplicates it lists and restart again, as Neo4j will not create a constraint over duplicate data",
+			Severity:         2,
+			TechnicalSummary: fmt.Sprintf("Compares db.indexes() and db.constraints() on %s with the schema created by Initialise", cr),
+			Checker:          hc.CheckSchema,
+		},
+		{
+			BusinessImpact:   "Memberships cannot be written, or writes are not visible to reads",
+			Name:             "Check that a value written to Neo4j can be read back",
+			PanicGuide:       "Check that the configured neo-url points at a writable Neo4j instance (the leader of a cluster) and that it is not in read-only mode or out of disk space",
+			Severity:         1,
+			TechnicalSummary: fmt.Sprintf("Writes a timestamp to a MembershipsRWHealthCheck sentinel node on %s and reads it back", cr),
+			Checker:          hc.CheckRoundTrip,
+		},
+		{
+			BusinessImpact:   "Memberships may have been deleted, so consumers may be missing data about people's roles in organisations",
+			Name:             "Check that the membership count has not dropped suddenly",
+			PanicGuide:       "Compare /memberships/__count with the count in another environment and look for DELETE requests in the logs. Restore the missing memberships by re-running the memberships bulk load. If the drop was expected, restart the service to reset the baseline, or wait for countBaselineWindowMs to pass",
+			Severity:         2,
+			TechnicalSummary: "Fails when the number of Membership nodes drops by more than the configured percentage since the last healthy check",
+			Checker:          hc.CheckCount,
+		},
+		{
+			BusinessImpact:   "Reads and writes of memberships are slow, which may slow down the bulk load and page renders that depend on them",
+			Name:             "Check that Neo4j query latency is acceptable",
+			PanicGuide:       "Check the CPU, memory and heap usage of the Neo4j instances and look for long running queries with CALL dbms.listQueries(). Restart the slow Neo4j instance if it does not recover",
+			Severity:         2,
+			TechnicalSummary: fmt.Sprintf("Fails when a single membership lookup against %s takes longer than the configured threshold", cr),
+			Checker:          hc.CheckLatency,
+		},
+	}
+}
+
+func makeClusterCheck(db *pendingConnection) fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Memberships may not be written, or reads may be slow or stale, if a Neo4j cluster member is down or the cluster has no leader",
+		Name:             "Check the status of each Neo4j cluster member",
+		PanicGuide:       "Check the logs of any Neo4j instance listed as unreachable and restart it. If no member is the leader, check that a majority of the core members are up so that they can elect one. This service finds the new leader itself and does not need restarting",
+		Severity:         2,
+		TechnicalSummary: fmt.Sprintf("Lists the members of the cluster behind %s with dbms.cluster.overview(), then connects to each directly and reports its role", db),
+		Checker: func() (string, error) {
+			conn, err := db.connection()
+			if err != nil {
+				return "", err
+			}
+			cluster, ok := conn.(*bolt.Connection)
+			if !ok {
+				return "the REST API does not list cluster members", nil
+			}
+			return cluster.CheckMembers()
+		},
 	}
 }
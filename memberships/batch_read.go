@@ -0,0 +1,67 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// MaxBatchReadSize is the most uuids a batch read may ask for at once
+const MaxBatchReadSize = 1000
+
+type batchReadRequest struct {
+	UUIDS []string `json:"uuids"`
+}
+
+// BatchReadResult holds the memberships a batch read found, keyed by uuid, and the uuids it did not find
+type BatchReadResult struct {
+	Memberships map[string]membership `json:"memberships"`
+	Missing     []string              `json:"missing"`
+}
+
+// BatchRead reads the memberships with the given uuids in one query, listing those not found as missing,
+// in the order they were asked for
+func (s service) BatchRead(ctx context.Context, uuids []string) (BatchReadResult, error) {
+	found, err := s.store.ReadMany(ctx, uuids)
+	if err != nil {
+		return BatchReadResult{}, err
+	}
+
+	result := BatchReadResult{Memberships: found, Missing: []string{}}
+	seen := map[string]bool{}
+	for _, uuid := range uuids {
+		if _, ok := found[uuid]; !ok && !seen[uuid] {
+			result.Missing = append(result.Missing, uuid)
+		}
+		seen[uuid] = true
+	}
+	return result, nil
+}
+
+// BatchReadHandler reads the memberships listed in a {"uuids": [...]} body
+func (s service) BatchReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONMessage(w, http.StatusMethodNotAllowed, "batch read with a POST")
+		return
+	}
+
+	req := batchReadRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, "body must be a JSON object with a list of uuids")
+		return
+	}
+	if len(req.UUIDS) > MaxBatchReadSize {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("at most %d uuids may be read at once", MaxBatchReadSize))
+		return
+	}
+
+	result, err := s.BatchRead(requestContext(r), req.UUIDS)
+	if err != nil {
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(result)
+}
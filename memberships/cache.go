@@ -0,0 +1,255 @@
+package memberships
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// InvalidatePath is where instances tell each other that a membership has changed
+const InvalidatePath = "/memberships/__invalidate"
+
+// CacheConfig configures the read-through cache in front of a MembershipStore
+type CacheConfig struct {
+	// Size is the number of memberships to keep, dropping the least recently read first
+	Size int
+	// TTL is how long a membership is served from the cache before it is read again
+	TTL time.Duration
+	// Peers are the base URLs of the other instances, which are told to drop a membership from their
+	// caches when this instance writes or deletes it
+	Peers []string
+}
+
+type cachedRead struct {
+	m       membership
+	found   bool
+	expires time.Time
+}
+
+// cachingStore serves Read from an LRU cache, passing everything else to the store it wraps.
+// Memberships that were not found are cached too, as page renders often ask for the same missing uuids.
+type cachingStore struct {
+	MembershipStore
+	cache  *lru.Cache
+	ttl    time.Duration
+	peers  []string
+	client *http.Client
+
+	// generation counts invalidations, so that a read that started before one does not cache what it read
+	mu         sync.Mutex
+	generation uint64
+
+	hits          metrics.Counter
+	misses        metrics.Counter
+	invalidations metrics.Counter
+}
+
+// NewCachingStore puts a read-through cache in front of store, counting hits and misses in the metrics
+// that are sent to graphite
+func NewCachingStore(store MembershipStore, conf CacheConfig) (*cachingStore, error) {
+	return newCachingStore(store, conf, metrics.DefaultRegistry)
+}
+
+func newCachingStore(store MembershipStore, conf CacheConfig, registry metrics.Registry) (*cachingStore, error) {
+	cache, err := lru.New(conf.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &cachingStore{
+		MembershipStore: store,
+		cache:           cache,
+		ttl:             conf.TTL,
+		peers:           conf.Peers,
+		client:          &http.Client{Timeout: 5 * time.Second},
+		hits:            metrics.GetOrRegisterCounter("memberships.cache.hits", registry),
+		misses:          metrics.GetOrRegisterCounter("memberships.cache.misses", registry),
+		invalidations:   metrics.GetOrRegisterCounter("memberships.cache.invalidations", registry),
+	}, nil
+}
+
+func (s *cachingStore) Read(ctx context.Context, uuid string) (membership, bool, error) {
+	if v, ok := s.cache.Get(uuid); ok {
+		cached := v.(cachedRead)
+		if time.Now().Before(cached.expires) {
+			s.hits.Inc(1)
+			if !cached.found {
+				return membership{}, false, nil
+			}
+			return asRead(cached.m), true, nil
+		}
+		s.cache.Remove(uuid)
+	}
+	s.misses.Inc(1)
+
+	generation := s.currentGeneration()
+	m, found, err := s.MembershipStore.Read(ctx, uuid)
+	if err != nil {
+		return m, found, err
+	}
+
+	s.mu.Lock()
+	if s.generation == generation {
+		s.cache.Add(uuid, cachedRead{m: asRead(m), found: found, expires: time.Now().Add(s.ttl)})
+	}
+	s.mu.Unlock()
+	return m, found, nil
+}
+
+// ReadMany serves what it can from the cache and reads the rest from the store in one go, caching what it reads
+func (s *cachingStore) ReadMany(ctx context.Context, uuids []string) (map[string]membership, error) {
+	found := map[string]membership{}
+	toRead := []string{}
+	now := time.Now()
+	for _, uuid := range uuids {
+		v, ok := s.cache.Get(uuid)
+		if !ok || !now.Before(v.(cachedRead).expires) {
+			toRead = append(toRead, uuid)
+			continue
+		}
+		s.hits.Inc(1)
+		if cached := v.(cachedRead); cached.found {
+			found[uuid] = asRead(cached.m)
+		}
+	}
+	if len(toRead) == 0 {
+		return found, nil
+	}
+	s.misses.Inc(int64(len(toRead)))
+
+	generation := s.currentGeneration()
+	read, err := s.MembershipStore.ReadMany(ctx, toRead)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := time.Now().Add(s.ttl)
+	for _, uuid := range toRead {
+		m, ok := read[uuid]
+		if s.generation == generation {
+			s.cache.Add(uuid, cachedRead{m: asRead(m), found: ok, expires: expires})
+		}
+		if ok {
+			found[uuid] = m
+		}
+	}
+	return found, nil
+}
+
+func (s *cachingStore) Write(ctx context.Context, m membership) error {
+	err := s.MembershipStore.Write(ctx, m)
+	s.invalidate(m.UUID)
+	s.broadcast(ctx, m.UUID)
+	return err
+}
+
+func (s *cachingStore) WriteMany(ctx context.Context, ms []membership) error {
+	err := s.MembershipStore.WriteMany(ctx, ms)
+	for _, m := range ms {
+		s.invalidate(m.UUID)
+		s.broadcast(ctx, m.UUID)
+	}
+	return err
+}
+
+func (s *cachingStore) Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error) {
+	m, found, err := s.MembershipStore.Update(ctx, uuid, change)
+	s.invalidate(uuid)
+	s.broadcast(ctx, uuid)
+	return m, found, err
+}
+
+func (s *cachingStore) Create(ctx context.Context, m membership) (bool, error) {
+	created, err := s.MembershipStore.Create(ctx, m)
+	s.invalidate(m.UUID)
+	s.broadcast(ctx, m.UUID)
+	return created, err
+}
+
+func (s *cachingStore) Delete(ctx context.Context, uuid string) (bool, error) {
+	found, err := s.MembershipStore.Delete(ctx, uuid)
+	s.invalidate(uuid)
+	s.broadcast(ctx, uuid)
+	return found, err
+}
+
+func (s *cachingStore) DeleteIf(ctx context.Context, uuid string, check func(membership) error) (bool, error) {
+	found, err := s.MembershipStore.DeleteIf(ctx, uuid, check)
+	s.invalidate(uuid)
+	s.broadcast(ctx, uuid)
+	return found, err
+}
+
+func (s *cachingStore) WriteRole(ctx context.Context, uuid string, r role) (bool, error) {
+	found, err := s.MembershipStore.WriteRole(ctx, uuid, r)
+	s.invalidate(uuid)
+	s.broadcast(ctx, uuid)
+	return found, err
+}
+
+func (s *cachingStore) DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error) {
+	found, err := s.MembershipStore.DeleteRole(ctx, uuid, roleUUID)
+	s.invalidate(uuid)
+	s.broadcast(ctx, uuid)
+	return found, err
+}
+
+func (s *cachingStore) currentGeneration() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generation
+}
+
+// invalidate drops uuid from this instance's cache
+func (s *cachingStore) invalidate(uuid string) {
+	s.mu.Lock()
+	s.generation++
+	s.cache.Remove(uuid)
+	s.mu.Unlock()
+	s.invalidations.Inc(1)
+}
+
+// broadcast tells the peers to drop uuid from their caches. It does not wait for them, and a peer that
+// misses the message serves the old membership until its TTL runs out.
+func (s *cachingStore) broadcast(ctx context.Context, uuid string) {
+	for _, peer := range s.peers {
+		go func(peer string) {
+			start := time.Now()
+			target := strings.TrimSuffix(peer, "/") + InvalidatePath + "?uuid=" + url.QueryEscape(uuid)
+			resp, err := s.client.Post(target, "", nil)
+			if err != nil {
+				logEntry(ctx, "invalidate", uuid, start).WithError(err).WithField("peer", peer).Warn("Could not invalidate the peer's cached membership")
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				logEntry(ctx, "invalidate", uuid, start).WithFields(log.Fields{"peer": peer, "status": resp.StatusCode}).Warn("Could not invalidate the peer's cached membership")
+			}
+		}(peer)
+	}
+}
+
+// InvalidateHandler drops the membership in ?uuid= from this instance's cache, without telling the peers
+func (s *cachingStore) InvalidateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONMessage(w, http.StatusMethodNotAllowed, "invalidate with a POST")
+		return
+	}
+	uuid := r.URL.Query().Get("uuid")
+	if uuid == "" {
+		writeJSONMessage(w, http.StatusBadRequest, "uuid is required")
+		return
+	}
+	start := time.Now()
+	s.invalidate(uuid)
+	logEntry(requestContext(r), "invalidate", uuid, start).Debug("Dropped the membership from the cache at a peer's request")
+	w.WriteHeader(http.StatusNoContent)
+}
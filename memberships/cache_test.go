@@ -0,0 +1,131 @@
+package memberships
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+var ctx = context.Background()
+
+// countingStore counts the reads that reach the store behind the cache
+type countingStore struct {
+	MembershipStore
+	reads int
+}
+
+func (s *countingStore) Read(ctx context.Context, uuid string) (membership, bool, error) {
+	s.reads++
+	return s.MembershipStore.Read(ctx, uuid)
+}
+
+func newTestCache(t *testing.T, conf CacheConfig) (*cachingStore, *countingStore) {
+	backing := &countingStore{MembershipStore: NewMemoryStore()}
+	cache, err := newCachingStore(backing, conf, metrics.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache, backing
+}
+
+func TestCachingStoreBehavesLikeAStore(t *testing.T) {
+	for name, behaviour := range storeBehaviours {
+		t.Run(name, func(t *testing.T) {
+			cache, _ := newTestCache(t, CacheConfig{Size: 10, TTL: time.Minute})
+			behaviour(t, NewMembershipService(cache))
+		})
+	}
+}
+
+func TestCachingStoreServesRepeatReadsFromTheCache(t *testing.T) {
+	assert := assert.New(t)
+	cache, backing := newTestCache(t, CacheConfig{Size: 10, TTL: time.Minute})
+	assert.NoError(cache.Write(ctx, fullMembership))
+
+	for i := 0; i < 3; i++ {
+		m, found, err := cache.Read(ctx, membershipUUID)
+		assert.NoError(err)
+		assert.True(found)
+		assert.Equal(fullMembership.PrefLabel, m.PrefLabel)
+	}
+	_, found, _ := cache.Read(ctx, "missing")
+	assert.False(found)
+	_, found, _ = cache.Read(ctx, "missing")
+	assert.False(found, "Cached as not found")
+
+	assert.Equal(2, backing.reads)
+	assert.Equal(int64(3), cache.hits.Count())
+	assert.Equal(int64(2), cache.misses.Count())
+}
+
+func TestCachingStoreInvalidatesOnWriteAndDelete(t *testing.T) {
+	assert := assert.New(t)
+	cache, _ := newTestCache(t, CacheConfig{Size: 10, TTL: time.Minute})
+	assert.NoError(cache.Write(ctx, fullMembership))
+	cache.Read(ctx, membershipUUID)
+
+	updated := fullMembership
+	updated.PrefLabel = "Updated label"
+	assert.NoError(cache.Write(ctx, updated))
+	m, _, _ := cache.Read(ctx, membershipUUID)
+	assert.Equal("Updated label", m.PrefLabel)
+
+	_, err := cache.Delete(ctx, membershipUUID)
+	assert.NoError(err)
+	_, found, _ := cache.Read(ctx, membershipUUID)
+	assert.False(found)
+}
+
+func TestCachingStoreRereadsAfterTTL(t *testing.T) {
+	cache, backing := newTestCache(t, CacheConfig{Size: 10, TTL: time.Millisecond})
+	assert.NoError(t, cache.Write(ctx, fullMembership))
+
+	cache.Read(ctx, membershipUUID)
+	time.Sleep(5 * time.Millisecond)
+	cache.Read(ctx, membershipUUID)
+
+	assert.Equal(t, 2, backing.reads)
+}
+
+func TestCachingStoreTellsPeersToInvalidate(t *testing.T) {
+	assert := assert.New(t)
+	peer, backing := newTestCache(t, CacheConfig{Size: 10, TTL: time.Minute})
+	server := httptest.NewServer(http.HandlerFunc(peer.InvalidateHandler))
+	defer server.Close()
+
+	// The peer shares the store but caches on its own
+	writer, err := newCachingStore(backing.MembershipStore, CacheConfig{Size: 10, TTL: time.Minute, Peers: []string{server.URL}}, metrics.NewRegistry())
+	assert.NoError(err)
+	assert.NoError(writer.Write(ctx, fullMembership))
+	peer.Read(ctx, membershipUUID)
+
+	updated := fullMembership
+	updated.PrefLabel = "Updated label"
+	assert.NoError(writer.Write(ctx, updated))
+
+	assert.Eventually(func() bool {
+		m, _, _ := peer.Read(ctx, membershipUUID)
+		return m.PrefLabel == "Updated label"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidateHandlerNeedsAPostWithAUUID(t *testing.T) {
+	cache, _ := newTestCache(t, CacheConfig{Size: 10, TTL: time.Minute})
+
+	w := httptest.NewRecorder()
+	cache.InvalidateHandler(w, httptest.NewRequest("GET", InvalidatePath+"?uuid=a", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	cache.InvalidateHandler(w, httptest.NewRequest("POST", InvalidatePath, nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	cache.InvalidateHandler(w, httptest.NewRequest("POST", InvalidatePath+"?uuid=a", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
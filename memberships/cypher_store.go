@@ -0,0 +1,985 @@
+package memberships
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+)
+
+type cypherStore struct {
+	conn neoutils.NeoConnection
+	// direct runs the guarded writes, which must not share a transaction with anyone else's statements
+	direct neoutils.CypherRunner
+}
+
+// NewCypherStore returns a MembershipStore that keeps memberships in neo4j
+func NewCypherStore(cypherRunner neoutils.NeoConnection) cypherStore {
+	return cypherStore{cypherRunner, cypherRunner}
+}
+
+// WithDirectConnection runs Update's reads and guarded writes on conn. It is needed when the store's connection
+// merges batches from different callers into one transaction, as neoutils does when given a batch size, so that a
+// guarded write is not rolled back or held up by other callers' statements.
+func (s cypherStore) WithDirectConnection(conn neoutils.CypherRunner) cypherStore {
+	s.direct = conn
+	return s
+}
+
+// indexes and constraints map a node label to the property that the migrations index or make unique
+var indexes = map[string]string{
+	"Identifier": "value",
+}
+
+var constraints = map[string]string{
+	"Thing":             "uuid",
+	"Concept":           "uuid",
+	"Membership":        "uuid",
+	"FactsetIdentifier": "value",
+	"UPPIdentifier":     "value",
+}
+
+// read runs read-only queries, on a follower or read replica when the connection can route them there
+func (s cypherStore) read(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return tracing.Run(ctx, s.conn, true, queries)
+}
+
+// write runs queries in a write transaction, tracing them under the span in ctx
+func (s cypherStore) write(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return tracing.Run(ctx, s.conn, false, queries)
+}
+
+// writeDirect runs queries in a write transaction of their own
+func (s cypherStore) writeDirect(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return tracing.Run(ctx, s.direct, false, queries)
+}
+
+func (s cypherStore) Initialise() error {
+	_, err := s.Migrate()
+	return err
+}
+
+// readMembershipsReturn completes a query that has matched a membership as m and its organisation as o,
+// returning it in the shape of membership, one row per membership. The organisation, person, roles and identifiers
+// are each aggregated before matching the next, as matching them all at once returns a row for every combination
+// of them. A membership with more than one organisation, person or FactSet identifier, which the integrity checks
+// report, is read with the lowest uuid or value of each.
+var readMembershipsReturn = `
+					WITH m, min(o.uuid) as organisationUuid
+					OPTIONAL MATCH (p:Thing)<-[:HAS_MEMBER]-(m)
+					WITH m, organisationUuid, min(p.uuid) as personUuid
+					OPTIONAL MATCH (r:Thing)<-[rr:HAS_ROLE]-(m)
+					WITH m, organisationUuid, personUuid, collect({roleuuid:r.uuid,inceptionDate:` + dateAt("rr.inceptionDate") + `,terminationDate:` + dateAt("rr.terminationDate") + `}) as membershipRoles
+					OPTIONAL MATCH (upp:UPPIdentifier)-[:IDENTIFIES]->(m)
+					WITH m, organisationUuid, personUuid, membershipRoles, collect(distinct upp.value) as uppUUIDs
+					OPTIONAL MATCH (fs:FactsetIdentifier)-[:IDENTIFIES]->(m)
+					WITH m, organisationUuid, personUuid, membershipRoles, uppUUIDs, min(fs.value) as factsetIdentifier
+					return
+						m.uuid as uuid,
+						m.prefLabel as prefLabel,
+						` + dateAt("m.inceptionDate") + ` as inceptionDate,
+						` + dateAt("m.terminationDate") + ` as terminationDate,
+						organisationUuid,
+						personUuid,
+						membershipRoles,
+						{uuids:uppUUIDs, factsetIdentifier:factsetIdentifier} as alternativeIdentifiers,
+						CASE WHEN m.sourceAuthority IS NULL THEN null
+							ELSE {authority:m.sourceAuthority, system:m.sourceSystem, recordId:m.sourceRecordId, ingestedAt:` + dateAt("m.sourceIngestedAt") + `} END as source`
+
+func (s cypherStore) Read(ctx context.Context, uuid string) (membership, bool, error) {
+	start := time.Now()
+	results := []membership{}
+	err := s.read(ctx, []*neoism.CypherQuery{readMembershipQuery(uuid, &results)})
+
+	if err != nil {
+		return membership{}, false, err
+	}
+
+	if len(results) == 0 {
+		return membership{}, false, nil
+	}
+
+	result := results[0]
+	removeEmptyRole(&result)
+	logEntry(ctx, "read", uuid, start).Debug("Read membership")
+
+	return result, true, nil
+}
+
+func readMembershipQuery(uuid string, results *[]membership) *neoism.CypherQuery {
+	return &neoism.CypherQuery{
+		Statement: `// read membership
+		MATCH (m:Membership {uuid:$uuid})-[:HAS_ORGANISATION]->(o:Thing)` + readMembershipsReturn,
+
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: results,
+	}
+}
+
+func (s cypherStore) ReadMany(ctx context.Context, uuids []string) (map[string]membership, error) {
+	results := []membership{}
+
+	query := &neoism.CypherQuery{
+		Statement: `// read memberships
+		UNWIND $uuids as uuid
+		MATCH (m:Membership {uuid:uuid})-[:HAS_ORGANISATION]->(o:Thing)` + readMembershipsReturn,
+
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+		},
+		Result: &results,
+	}
+	if err := s.read(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]membership, len(results))
+	for _, m := range results {
+		removeEmptyRole(&m)
+		found[m.UUID] = m
+	}
+	return found, nil
+}
+
+func (s cypherStore) ReadLabels(ctx context.Context, uuids []string) (map[string]string, error) {
+	results := []struct {
+		UUID      string `json:"uuid"`
+		PrefLabel string `json:"prefLabel"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `// read labels
+		UNWIND $uuids as uuid
+		MATCH (t:Thing {uuid:uuid})
+		WHERE t.prefLabel IS NOT NULL
+		RETURN t.uuid as uuid, t.prefLabel as prefLabel`,
+		Parameters: map[string]interface{}{
+			"uuids": uuids,
+		},
+		Result: &results,
+	}
+	if err := s.read(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(results))
+	for _, r := range results {
+		labels[r.UUID] = r.PrefLabel
+	}
+	return labels, nil
+}
+
+// removeEmptyRole drops the role that collect returns for a membership with no HAS_ROLE relationships, and merges
+// the relationships to the same role, one for each period, into a role with all of them
+func removeEmptyRole(m *membership) {
+	if len(m.MembershipRoles) == 1 && (m.MembershipRoles[0].RoleUUID == "") {
+		m.MembershipRoles = make([]role, 0, 0)
+		return
+	}
+	m.MembershipRoles = normaliseRoles(m.MembershipRoles)
+}
+
+func (s cypherStore) ReadPage(ctx context.Context, after string, since time.Time, limit int) ([]membership, string, error) {
+	filter := ""
+	params := map[string]interface{}{
+		"after": after,
+		"limit": limit,
+	}
+	if !since.IsZero() {
+		filter = "AND m.lastModifiedEpoch >= $since"
+		params["since"] = since.Unix()
+	}
+
+	results := []membership{}
+	query := &neoism.CypherQuery{
+		Statement: `// read membership page
+		MATCH (m:Membership)
+		WHERE m.uuid > $after ` + filter + `
+		WITH m ORDER BY m.uuid LIMIT $limit
+		OPTIONAL MATCH (m)-[:HAS_ORGANISATION]->(o:Thing)` + readMembershipsReturn + `
+		ORDER BY uuid`,
+		Parameters: params,
+		Result:     &results,
+	}
+
+	if err := s.read(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return nil, "", err
+	}
+
+	// There is a row for each membership in the LIMIT, including those without an organisation left out below, so
+	// a full page of rows means there may be more
+	next := ""
+	if len(results) >= limit {
+		next = results[len(results)-1].UUID
+	}
+
+	page := make([]membership, 0, len(results))
+	for _, m := range results {
+		// Read does not find memberships without an organisation, so neither does a page
+		if m.OrganisationUUID == "" {
+			continue
+		}
+		removeEmptyRole(&m)
+		page = append(page, m)
+	}
+	return page, next, nil
+}
+
+func (s cypherStore) Write(ctx context.Context, m membership) error {
+	start := time.Now()
+	queries, err := writeQueries(m)
+	if err != nil {
+		return err
+	}
+	if err := s.write(ctx, queries); err != nil {
+		return err
+	}
+	logEntry(ctx, "write", m.UUID, start).WithField("query_count", len(queries)).Debug("Wrote membership")
+	return nil
+}
+
+// writeQueries returns the statements that replace the membership with m, or create it
+func writeQueries(m membership) ([]*neoism.CypherQuery, error) {
+	queries := []*neoism.CypherQuery{}
+	props, err := membershipProps(m)
+	if err != nil {
+		return nil, err
+	}
+
+	//cleanUP all the previous IDENTIFIERS referring to that uuid
+	deletePreviousIdentifiersQuery := &neoism.CypherQuery{
+		Statement: `// delete previous identifiers
+		MATCH (t:Thing {uuid:$uuid})
+		OPTIONAL MATCH (t)<-[iden:IDENTIFIES]-(i)
+		DELETE iden, i`,
+		Parameters: map[string]interface{}{
+			"uuid": m.UUID,
+		},
+	}
+	queries = append(queries, deletePreviousIdentifiersQuery)
+
+	queryDelEntitiesRel := &neoism.CypherQuery{
+		Statement: `// delete person and organisation relationships
+					MATCH (m:Thing {uuid: $uuid})
+					OPTIONAL MATCH (p:Thing)<-[rm:HAS_MEMBER]-(m)
+					OPTIONAL MATCH (o:Thing)<-[ro:HAS_ORGANISATION]-(m)
+					DELETE rm, ro
+		`,
+		Parameters: map[string]interface{}{
+			"uuid": m.UUID,
+		},
+	}
+	queries = append(queries, queryDelEntitiesRel)
+
+	if m.AlternativeIdentifiers.FactsetIdentifier != "" {
+		q := createNewIdentifierQuery(
+			m.UUID,
+			factsetIdentifierLabel,
+			m.AlternativeIdentifiers.FactsetIdentifier,
+		)
+		queries = append(queries, q)
+	}
+
+	for _, alternativeUUID := range m.AlternativeIdentifiers.UUIDS {
+		q := createNewIdentifierQuery(m.UUID, uppIdentifierLabel, alternativeUUID)
+		queries = append(queries, q)
+	}
+
+	// m is locked before its revision is read, so that two writes cannot both increment it from the same value
+	createMembershipQuery := &neoism.CypherQuery{
+		Statement: `// create membership
+			    MERGE (m:Thing	 {uuid: $uuid})
+			    SET m._lock = true
+			    WITH m, coalesce(m.revision, 0) + 1 as revision
+			    MERGE (personUPP:Identifier:UPPIdentifier{value:$personuuid})
+                            MERGE (personUPP)-[:IDENTIFIES]->(p:Thing) ON CREATE SET p.uuid = $personuuid
+			    MERGE (orgUPP:Identifier:UPPIdentifier{value:$organisationuuid})
+                            MERGE (orgUPP)-[:IDENTIFIES]->(o:Thing) ON CREATE SET o.uuid = $organisationuuid
+			    CREATE(m)-[:HAS_MEMBER]->(p)
+		            CREATE (m)-[:HAS_ORGANISATION]->(o)
+					set m=$allprops
+					set m.revision = revision
+					set m :Concept
+					set m :Membership
+		`,
+		Parameters: map[string]interface{}{
+			"uuid":             m.UUID,
+			"allprops":         props,
+			"personuuid":       m.PersonUUID,
+			"organisationuuid": m.OrganisationUUID,
+		},
+	}
+
+	queries = append(queries, createMembershipQuery)
+
+	queryDelRolesRel := &neoism.CypherQuery{
+		Statement: `// delete role relationships
+					MATCH (m:Thing {uuid: $uuid})
+					OPTIONAL MATCH (r:Thing)<-[rr:HAS_ROLE]-(m)
+					DELETE  rr
+		`,
+		Parameters: map[string]interface{}{
+			"uuid": m.UUID,
+		},
+	}
+	queries = append(queries, queryDelRolesRel)
+
+	for _, mr := range normaliseRoles(m.MembershipRoles) {
+		for _, p := range mr.Periods {
+			rrparams, err := periodProps(p)
+			if err != nil {
+				return nil, err
+			}
+			q := &neoism.CypherQuery{
+				Statement: `// create role
+					MERGE (m:Thing {uuid:$muuid})
+					MERGE (roleUPP:Identifier:UPPIdentifier{value:$ruuid})
+					MERGE (roleUPP)-[:IDENTIFIES]->(r:Thing) ON CREATE SET r.uuid = $ruuid
+					CREATE (m)-[rel:HAS_ROLE]->(r)
+					SET rel=$rrparams
+				`,
+				Parameters: map[string]interface{}{
+					"muuid":    m.UUID,
+					"ruuid":    mr.RoleUUID,
+					"rrparams": rrparams,
+				},
+			}
+
+			queries = append(queries, q)
+		}
+	}
+	return queries, nil
+}
+
+// WriteMany writes the memberships in one transaction of seven statements, however many there are, each
+// unwinding a list with an entry per membership, identifier or role. It leaves the graph as calling Write for
+// each membership in turn would, so if a uuid appears more than once the last one wins.
+func (s cypherStore) WriteMany(ctx context.Context, ms []membership) error {
+	start := time.Now()
+	ms = lastOfEachUUID(ms)
+
+	uuids := make([]string, 0, len(ms))
+	factsetIdentifiers := []map[string]interface{}{}
+	uppIdentifiers := []map[string]interface{}{}
+	memberships := make([]map[string]interface{}, 0, len(ms))
+	roles := []map[string]interface{}{}
+
+	for _, m := range ms {
+		uuids = append(uuids, m.UUID)
+		if m.AlternativeIdentifiers.FactsetIdentifier != "" {
+			factsetIdentifiers = append(factsetIdentifiers, map[string]interface{}{"uuid": m.UUID, "value": m.AlternativeIdentifiers.FactsetIdentifier})
+		}
+		for _, alternativeUUID := range m.AlternativeIdentifiers.UUIDS {
+			uppIdentifiers = append(uppIdentifiers, map[string]interface{}{"uuid": m.UUID, "value": alternativeUUID})
+		}
+		props, err := membershipProps(m)
+		if err != nil {
+			return err
+		}
+		memberships = append(memberships, map[string]interface{}{
+			"uuid":             m.UUID,
+			"allprops":         props,
+			"personuuid":       m.PersonUUID,
+			"organisationuuid": m.OrganisationUUID,
+		})
+		for _, mr := range normaliseRoles(m.MembershipRoles) {
+			for _, p := range mr.Periods {
+				rrparams, err := periodProps(p)
+				if err != nil {
+					return err
+				}
+				roles = append(roles, map[string]interface{}{
+					"muuid":    m.UUID,
+					"ruuid":    mr.RoleUUID,
+					"rrparams": rrparams,
+				})
+			}
+		}
+	}
+
+	queries := []*neoism.CypherQuery{
+		{
+			Statement: `// delete previous identifiers
+					UNWIND $uuids as uuid
+					MATCH (t:Thing {uuid:uuid})
+					OPTIONAL MATCH (t)<-[iden:IDENTIFIES]-(i)
+					DELETE iden, i`,
+			Parameters: map[string]interface{}{"uuids": uuids},
+		},
+		{
+			Statement: `// delete person and organisation relationships
+					UNWIND $uuids as uuid
+					MATCH (m:Thing {uuid: uuid})
+					OPTIONAL MATCH (p:Thing)<-[rm:HAS_MEMBER]-(m)
+					OPTIONAL MATCH (o:Thing)<-[ro:HAS_ORGANISATION]-(m)
+					DELETE rm, ro`,
+			Parameters: map[string]interface{}{"uuids": uuids},
+		},
+		createNewIdentifiersQuery(factsetIdentifierLabel, factsetIdentifiers),
+		createNewIdentifiersQuery(uppIdentifierLabel, uppIdentifiers),
+		{
+			Statement: `// create memberships
+					UNWIND $memberships as mem
+					MERGE (m:Thing {uuid: mem.uuid})
+					SET m._lock = true
+					WITH mem, m, coalesce(m.revision, 0) + 1 as revision
+					MERGE (personUPP:Identifier:UPPIdentifier{value:mem.personuuid})
+					MERGE (personUPP)-[:IDENTIFIES]->(p:Thing) ON CREATE SET p.uuid = mem.personuuid
+					MERGE (orgUPP:Identifier:UPPIdentifier{value:mem.organisationuuid})
+					MERGE (orgUPP)-[:IDENTIFIES]->(o:Thing) ON CREATE SET o.uuid = mem.organisationuuid
+					CREATE (m)-[:HAS_MEMBER]->(p)
+					CREATE (m)-[:HAS_ORGANISATION]->(o)
+					SET m=mem.allprops
+					SET m.revision = revision
+					SET m :Concept
+					SET m :Membership`,
+			Parameters: map[string]interface{}{"memberships": memberships},
+		},
+		{
+			Statement: `// delete role relationships
+					UNWIND $uuids as uuid
+					MATCH (m:Thing {uuid: uuid})
+					OPTIONAL MATCH (r:Thing)<-[rr:HAS_ROLE]-(m)
+					DELETE rr`,
+			Parameters: map[string]interface{}{"uuids": uuids},
+		},
+		{
+			Statement: `// create roles
+					UNWIND $roles as role
+					MERGE (m:Thing {uuid:role.muuid})
+					MERGE (roleUPP:Identifier:UPPIdentifier{value:role.ruuid})
+					MERGE (roleUPP)-[:IDENTIFIES]->(r:Thing) ON CREATE SET r.uuid = role.ruuid
+					CREATE (m)-[rel:HAS_ROLE]->(r)
+					SET rel=role.rrparams`,
+			Parameters: map[string]interface{}{"roles": roles},
+		},
+	}
+	if err := s.write(ctx, queries); err != nil {
+		return err
+	}
+	logEntry(ctx, "write_many", "", start).WithField("membership_count", len(ms)).Debug("Wrote memberships")
+	return nil
+}
+
+// membershipProps returns the properties Write sets on a membership node, or an error if one of its dates does not
+// parse
+func membershipProps(m membership) (map[string]interface{}, error) {
+	params := map[string]interface{}{
+		"uuid": m.UUID,
+	}
+
+	if m.PrefLabel != "" {
+		params["prefLabel"] = m.PrefLabel
+	}
+
+	dates := map[string]string{
+		"inceptionDate":   m.InceptionDate,
+		"terminationDate": m.TerminationDate,
+	}
+	if m.Source != nil {
+		params["sourceAuthority"] = m.Source.Authority
+		if m.Source.System != "" {
+			params["sourceSystem"] = m.Source.System
+		}
+		if m.Source.RecordID != "" {
+			params["sourceRecordId"] = m.Source.RecordID
+		}
+		dates["sourceIngestedAt"] = m.Source.IngestedAt
+	}
+	if err := addDatesToQueryParams(params, dates); err != nil {
+		return nil, err
+	}
+
+	for name, value := range lastModifiedProps() {
+		params[name] = value
+	}
+	return params, nil
+}
+
+// lastModifiedProps returns the lastModified property of a membership written now, and its epoch
+func lastModifiedProps() map[string]interface{} {
+	params := map[string]interface{}{}
+	// Formatted here as RFC 3339, so it always parses
+	addDateToQueryParams(params, "lastModified", time.Now().UTC().Format(time.RFC3339))
+	return params
+}
+
+// periodProps returns the properties Write sets on the HAS_ROLE relationship for a period of a role, or an error
+// if one of its dates does not parse
+func periodProps(mr period) (map[string]interface{}, error) {
+	rrparams := make(map[string]interface{})
+	err := addDatesToQueryParams(rrparams, map[string]string{
+		"inceptionDate":   mr.InceptionDate,
+		"terminationDate": mr.TerminationDate,
+	})
+	return rrparams, err
+}
+
+// checkDates returns the error writing m would give for a date that does not parse, so that the memory store
+// rejects the writes this store does
+func checkDates(m membership) error {
+	if _, err := membershipProps(m); err != nil {
+		return err
+	}
+	for _, r := range m.MembershipRoles {
+		if err := checkRoleDates(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkRoleDates(r role) error {
+	for _, p := range r.Periods {
+		if _, err := periodProps(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDatesToQueryParams adds each of the dates that is set, as addDateToQueryParams does, naming the first that
+// does not parse in the error
+func addDatesToQueryParams(params map[string]interface{}, dates map[string]string) error {
+	names := make([]string, 0, len(dates))
+	for name := range dates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if dates[name] == "" {
+			continue
+		}
+		if err := addDateToQueryParams(params, name, dates[name]); err != nil {
+			return fmt.Errorf("%s %w", name, err)
+		}
+	}
+	return nil
+}
+
+// lastOfEachUUID drops every membership that has a later one with the same uuid, keeping the order of the rest
+func lastOfEachUUID(ms []membership) []membership {
+	last := make(map[string]int, len(ms))
+	for i, m := range ms {
+		last[m.UUID] = i
+	}
+	kept := make([]membership, 0, len(last))
+	for i, m := range ms {
+		if last[m.UUID] == i {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func createNewIdentifierQuery(uuid string, identifierLabel string, identifierValue string) *neoism.CypherQuery {
+	statementTemplate := fmt.Sprintf(`// create identifier
+					MERGE (t:Thing {uuid:$uuid})
+					CREATE (i:Identifier {value:$value})
+					MERGE (t)<-[:IDENTIFIES]-(i)
+					set i : %s `, identifierLabel)
+	query := &neoism.CypherQuery{
+		Statement: statementTemplate,
+		Parameters: map[string]interface{}{
+			"uuid":  uuid,
+			"value": identifierValue,
+		},
+	}
+	return query
+}
+
+func createNewIdentifiersQuery(identifierLabel string, identifiers []map[string]interface{}) *neoism.CypherQuery {
+	statementTemplate := fmt.Sprintf(`// create identifiers
+					UNWIND $identifiers as ident
+					MERGE (t:Thing {uuid:ident.uuid})
+					CREATE (i:Identifier {value:ident.value})
+					MERGE (t)<-[:IDENTIFIES]-(i)
+					set i : %s `, identifierLabel)
+	return &neoism.CypherQuery{
+		Statement: statementTemplate,
+		Parameters: map[string]interface{}{
+			"identifiers": identifiers,
+		},
+	}
+}
+
+func (s cypherStore) ReadRole(ctx context.Context, uuid string, roleUUID string) (role, bool, error) {
+	results := []role{}
+	query := &neoism.CypherQuery{
+		Statement: `// read role
+			MATCH (m:Membership {uuid:$uuid})-[rr:HAS_ROLE]->(r:Thing {uuid:$roleuuid})
+			RETURN r.uuid as roleuuid, ` + dateAt("rr.inceptionDate") + ` as inceptionDate, ` + dateAt("rr.terminationDate") + ` as terminationDate`,
+		Parameters: map[string]interface{}{"uuid": uuid, "roleuuid": roleUUID},
+		Result:     &results,
+	}
+	if err := s.read(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return role{}, false, err
+	}
+	if len(results) == 0 {
+		return role{}, false, nil
+	}
+	return normaliseRoles(results)[0], true, nil
+}
+
+// WriteRole replaces the HAS_ROLE relationships from the membership to the role with one for each of r's periods
+func (s cypherStore) WriteRole(ctx context.Context, uuid string, r role) (bool, error) {
+	start := time.Now()
+	periods := []map[string]interface{}{}
+	for _, p := range normaliseRoles([]role{r})[0].Periods {
+		props, err := periodProps(p)
+		if err != nil {
+			return false, err
+		}
+		periods = append(periods, props)
+	}
+	found := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `// write role
+			MATCH (m:Membership {uuid:$uuid})
+			OPTIONAL MATCH (m)-[old:HAS_ROLE]->(:Thing {uuid:$roleuuid})
+			DELETE old
+			WITH DISTINCT m
+			MERGE (roleUPP:Identifier:UPPIdentifier{value:$roleuuid})
+			MERGE (roleUPP)-[:IDENTIFIES]->(r:Thing) ON CREATE SET r.uuid = $roleuuid
+			SET m += $modified
+			SET m.revision = coalesce(m.revision, 0) + 1
+			WITH m, r
+			UNWIND $periods as period
+			CREATE (m)-[rel:HAS_ROLE]->(r)
+			SET rel = period
+			RETURN DISTINCT m.uuid as uuid`,
+		Parameters: map[string]interface{}{
+			"uuid":     uuid,
+			"roleuuid": r.RoleUUID,
+			"periods":  periods,
+			"modified": lastModifiedProps(),
+		},
+		Result: &found,
+	}
+	if err := s.write(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return false, err
+	}
+	logEntry(ctx, "write_role", uuid, start).WithField("role_uuid", r.RoleUUID).Debug("Wrote role")
+	return len(found) > 0, nil
+}
+
+func (s cypherStore) DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error) {
+	start := time.Now()
+	found := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `// delete role
+			MATCH (m:Membership {uuid:$uuid})-[rr:HAS_ROLE]->(:Thing {uuid:$roleuuid})
+			DELETE rr
+			WITH DISTINCT m
+			SET m += $modified
+			SET m.revision = coalesce(m.revision, 0) + 1
+			RETURN m.uuid as uuid`,
+		Parameters: map[string]interface{}{
+			"uuid":     uuid,
+			"roleuuid": roleUUID,
+			"modified": lastModifiedProps(),
+		},
+		Result: &found,
+	}
+	if err := s.write(ctx, []*neoism.CypherQuery{query}); err != nil {
+		return false, err
+	}
+	logEntry(ctx, "delete_role", uuid, start).WithField("role_uuid", roleUUID).Debug("Deleted role")
+	return len(found) > 0, nil
+}
+
+// maxUpdateAttempts is how many times Update reads and writes a membership before giving up with ErrConflict
+const maxUpdateAttempts = 3
+
+// Update writes the membership only if its revision, which every write increments, is still the one it read.
+// If it is not, nothing is written, and the change is applied again to the membership as it now is.
+func (s cypherStore) Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error) {
+	start := time.Now()
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		current, revision, found, err := s.readForUpdate(ctx, uuid)
+		if err != nil || !found {
+			return membership{}, false, err
+		}
+
+		updated, err := change(current)
+		if err != nil {
+			return membership{}, true, err
+		}
+
+		queries, err := writeQueries(updated)
+		if err != nil {
+			return membership{}, true, err
+		}
+		written, err := s.writeIfRevision(ctx, uuid, revision, queries)
+		if err != nil {
+			return membership{}, true, err
+		}
+		if written {
+			logEntry(ctx, "update", uuid, start).WithField("attempt", attempt).Debug("Updated membership")
+			return asRead(updated), true, nil
+		}
+		logEntry(ctx, "update", uuid, start).WithField("attempt", attempt).Debug("Membership was written while updating it")
+	}
+	logEntry(ctx, "update", uuid, start).Warn("Gave up updating a membership that kept being written")
+	return membership{}, true, ErrConflict
+}
+
+// readForUpdate reads the membership and its revision from the leader in one transaction, so that the revision is
+// that of the membership read
+func (s cypherStore) readForUpdate(ctx context.Context, uuid string) (membership, int64, bool, error) {
+	revisions := []struct {
+		Revision int64 `json:"revision"`
+	}{}
+	results := []membership{}
+	read := []*neoism.CypherQuery{
+		{
+			Statement: `// read membership revision
+				MATCH (m:Membership {uuid:$uuid})
+				RETURN coalesce(m.revision, 0) as revision`,
+			Parameters: map[string]interface{}{"uuid": uuid},
+			Result:     &revisions,
+		},
+		readMembershipQuery(uuid, &results),
+	}
+	if err := s.writeDirect(ctx, read); err != nil {
+		return membership{}, 0, false, err
+	}
+	if len(results) == 0 || len(revisions) == 0 {
+		return membership{}, 0, false, nil
+	}
+	current := results[0]
+	removeEmptyRole(&current)
+	return current, revisions[0].Revision, true, nil
+}
+
+// Create writes the membership in one transaction with a statement that merges its node, locking it, and goes no
+// further if it is already a membership with an organisation. Merging on the uuid, which is unique, means that a
+// concurrent create waits for this one to commit and then finds the membership.
+func (s cypherStore) Create(ctx context.Context, m membership) (bool, error) {
+	start := time.Now()
+	queries, err := writeQueries(m)
+	if err != nil {
+		return false, err
+	}
+	created, err := s.writeGuarded(ctx, m.UUID, `MERGE (m:Thing {uuid:$uuid})
+			SET m._lock = true
+			REMOVE m._lock
+			WITH m WHERE NOT (m:Membership AND (m)-[:HAS_ORGANISATION]->())`, nil, queries)
+	if err != nil {
+		return false, err
+	}
+	logEntry(ctx, "create", m.UUID, start).WithField("created", created).Debug("Created membership")
+	return created, nil
+}
+
+// writeIfRevision runs queries as long as the membership's revision is still revision, returning whether it was.
+// The membership is locked before its revision is compared, so that no other write can come between the
+// comparison and the queries.
+func (s cypherStore) writeIfRevision(ctx context.Context, uuid string, revision int64, queries []*neoism.CypherQuery) (bool, error) {
+	return s.writeGuarded(ctx, uuid, `MATCH (m:Membership {uuid:$uuid})
+			SET m._lock = true
+			REMOVE m._lock
+			WITH m WHERE coalesce(m.revision, 0) = $revision`, map[string]interface{}{"revision": revision}, queries)
+}
+
+// writeGuarded runs queries in one transaction on the direct connection, if the guard, which matches the node with
+// the uuid as m, finds it, returning whether it did. The guard marks the transaction with a pending update node
+// that every query matches first, so if there is no such node the queries match nothing and write nothing. The
+// last statement deletes the node, so it is never committed.
+func (s cypherStore) writeGuarded(ctx context.Context, uuid string, guard string, params map[string]interface{}, queries []*neoism.CypherQuery) (bool, error) {
+	locked := []struct {
+		Locked int `json:"locked"`
+	}{}
+	lockParams := map[string]interface{}{"uuid": uuid}
+	for k, v := range params {
+		lockParams[k] = v
+	}
+	lock := &neoism.CypherQuery{
+		Statement: `// lock membership
+			` + guard + `
+			CREATE (:MembershipUpdate {uuid:$uuid})
+			RETURN count(m) as locked`,
+		Parameters: lockParams,
+		Result:     &locked,
+	}
+	unlock := &neoism.CypherQuery{
+		Statement: `// unlock membership
+			MATCH (pending:MembershipUpdate {uuid:$uuid})
+			DELETE pending`,
+		Parameters: map[string]interface{}{"uuid": uuid},
+	}
+
+	batch := []*neoism.CypherQuery{lock}
+	for _, q := range queries {
+		batch = append(batch, guardedByPendingUpdate(q, uuid))
+	}
+	if err := s.writeDirect(ctx, append(batch, unlock)); err != nil {
+		return false, err
+	}
+	return len(locked) > 0 && locked[0].Locked > 0, nil
+}
+
+// guardedByPendingUpdate makes q match the pending update node of the membership before anything else, keeping
+// the comment naming the statement on its first line
+func guardedByPendingUpdate(q *neoism.CypherQuery, uuid string) *neoism.CypherQuery {
+	name, rest, _ := strings.Cut(q.Statement, "\n")
+	params := map[string]interface{}{"pendinguuid": uuid}
+	for k, v := range q.Parameters {
+		params[k] = v
+	}
+	return &neoism.CypherQuery{
+		Statement: name + `
+			MATCH (:MembershipUpdate {uuid:$pendinguuid})
+			` + rest,
+		Parameters: params,
+		Result:     q.Result,
+	}
+}
+
+func (s cypherStore) Delete(ctx context.Context, uuid string) (bool, error) {
+	// Runs in the same transaction as the delete, so found is true only if this call removed the labels
+	found := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	if err := s.write(ctx, deleteQueries(uuid, &found)); err != nil {
+		return false, err
+	}
+	return len(found) > 0, nil
+}
+
+// DeleteIf reads the membership and its revision as Update does, and deletes it only if the revision is still the
+// one read. A node with the uuid that is not a membership, which check cannot be given, is deleted as Delete does.
+func (s cypherStore) DeleteIf(ctx context.Context, uuid string, check func(membership) error) (bool, error) {
+	start := time.Now()
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		current, revision, found, err := s.readForUpdate(ctx, uuid)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return s.Delete(ctx, uuid)
+		}
+		if err := check(current); err != nil {
+			return true, err
+		}
+
+		deleted := []struct {
+			UUID string `json:"uuid"`
+		}{}
+		written, err := s.writeIfRevision(ctx, uuid, revision, deleteQueries(uuid, &deleted))
+		if err != nil {
+			return true, err
+		}
+		if written {
+			logEntry(ctx, "delete", uuid, start).WithField("attempt", attempt).Debug("Deleted membership")
+			return true, nil
+		}
+		logEntry(ctx, "delete", uuid, start).WithField("attempt", attempt).Debug("Membership was written while deleting it")
+	}
+	logEntry(ctx, "delete", uuid, start).Warn("Gave up deleting a membership that kept being written")
+	return true, ErrConflict
+}
+
+// deleteQueries remove the membership's labels, properties and relationships, and the node itself if nothing else
+// refers to it, returning its uuid into found if it was a membership or concept
+func deleteQueries(uuid string, found interface{}) []*neoism.CypherQuery {
+	findMembership := &neoism.CypherQuery{
+		Statement: `// find membership
+				MATCH (m:Thing {uuid: $uuid})
+				WHERE m:Concept OR m:Membership
+				RETURN m.uuid as uuid
+		`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+		Result: found,
+	}
+
+	clearNode := &neoism.CypherQuery{
+		Statement: `// clear membership
+				MATCH (m:Thing {uuid: $uuid})
+				OPTIONAL MATCH (m)-[prel:HAS_MEMBER]->(p:Thing)
+				OPTIONAL MATCH (m)-[orel:HAS_ORGANISATION]->(o:Thing)
+				OPTIONAL MATCH (r:Thing)<-[rrel:HAS_ROLE]-(m)
+				OPTIONAL MATCH (m)<-[iden:IDENTIFIES]-(i:Identifier)
+				REMOVE m:Concept
+				REMOVE m:Membership
+				DELETE iden, i
+				SET m=$props
+				DELETE rrel, orel, prel
+		`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+			"props": map[string]interface{}{
+				"uuid": uuid,
+			},
+		},
+	}
+
+	removeNodeIfUnused := &neoism.CypherQuery{
+		Statement: `// remove unused node
+				MATCH (m:Thing {uuid: $uuid})
+				OPTIONAL MATCH (m)-[a]-(x)
+				WITH m, count(a) AS relCount
+				WHERE relCount = 0
+				DELETE m
+			`,
+		Parameters: map[string]interface{}{
+			"uuid": uuid,
+		},
+	}
+
+	return []*neoism.CypherQuery{findMembership, clearNode, removeNodeIfUnused}
+}
+
+func (s cypherStore) Check() error {
+	return neoutils.Check(s.conn)
+}
+
+func (s cypherStore) Count(ctx context.Context) (int, error) {
+
+	results := []struct {
+		Count int `json:"c"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `// count memberships
+		MATCH (n:Membership) return count(n) as c`,
+		Result: &results,
+	}
+
+	err := s.read(ctx, []*neoism.CypherQuery{query})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return results[0].Count, nil
+}
+
+// addDateToQueryParams sets the date property, and its epoch and precision if it parses. A partial date is stored as
+// the full timestamp it starts at, for anything reading the graph directly, and read back at its precision.
+func addDateToQueryParams(params map[string]interface{}, dateName string, dateVal string) error {
+	params[dateName] = dateVal
+	t, precision, err := parseDate(dateVal)
+	if err != nil {
+		return err
+	}
+	if precision != datetimePrecision {
+		params[dateName] = t.Format(time.RFC3339)
+	}
+	params[dateName+"Epoch"] = t.Unix()
+	params[dateName+"Precision"] = precision
+	return nil
+}
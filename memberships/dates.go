@@ -0,0 +1,53 @@
+package memberships
+
+import (
+	"fmt"
+	"time"
+)
+
+// The precisions a date may be written at, which are stored in the *Precision property next to its epoch
+const (
+	yearPrecision     = "year"
+	monthPrecision    = "month"
+	dayPrecision      = "day"
+	datetimePrecision = "datetime"
+)
+
+// dateLayouts are the formats a date may be written in, from the most precise. FactSet often knows only the year or
+// month a membership started.
+var dateLayouts = []struct {
+	layout    string
+	precision string
+}{
+	{time.RFC3339, datetimePrecision},
+	{"2006-01-02", dayPrecision},
+	{"2006-01", monthPrecision},
+	{"2006", yearPrecision},
+}
+
+// parseDate parses an RFC3339 date, or a partial date such as 2005 or 2005-06, returning the time it starts at and
+// its precision
+func parseDate(date string) (time.Time, string, error) {
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l.layout, date); err == nil {
+			return t, l.precision, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("%q is not an RFC 3339 date, or a year, month or day such as 2005-06", date)
+}
+
+// epochOf returns the Unix time a date starts at, which is stored alongside the date for range queries
+func epochOf(date string) (int64, error) {
+	t, _, err := parseDate(date)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// dateAt returns Cypher for the date property cut back to the precision it was written at, as partial dates are
+// stored as full timestamps. Dates without a precision are returned as they are.
+func dateAt(property string) string {
+	return fmt.Sprintf(`CASE %[1]sPrecision WHEN '%[2]s' THEN left(%[1]s, 4) WHEN '%[3]s' THEN left(%[1]s, 7) WHEN '%[4]s' THEN left(%[1]s, 10) ELSE %[1]s END`,
+		property, yearPrecision, monthPrecision, dayPrecision)
+}
@@ -0,0 +1,47 @@
+package memberships
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDate(t *testing.T) {
+	assert := assert.New(t)
+	for date, expected := range map[string]struct {
+		time      time.Time
+		precision string
+	}{
+		"2005":                     {time.Date(2005, 1, 1, 0, 0, 0, 0, time.UTC), yearPrecision},
+		"2005-06":                  {time.Date(2005, 6, 1, 0, 0, 0, 0, time.UTC), monthPrecision},
+		"2005-06-15":               {time.Date(2005, 6, 15, 0, 0, 0, 0, time.UTC), dayPrecision},
+		"2005-06-15T09:30:00.000Z": {time.Date(2005, 6, 15, 9, 30, 0, 0, time.UTC), datetimePrecision},
+	} {
+		parsed, precision, err := parseDate(date)
+		assert.NoError(err, date)
+		assert.True(expected.time.Equal(parsed), date)
+		assert.Equal(expected.precision, precision, date)
+	}
+
+	for _, date := range []string{"", "sometime", "2005-13", "05/06/2005"} {
+		_, _, err := parseDate(date)
+		assert.Error(err, date)
+	}
+}
+
+func TestAddDateToQueryParamsStoresPartialDatesAsTimestamps(t *testing.T) {
+	assert := assert.New(t)
+	params := map[string]interface{}{}
+
+	assert.NoError(addDateToQueryParams(params, "inceptionDate", "2005-06"))
+	assert.NoError(addDateToQueryParams(params, "terminationDate", "2007-01-01T00:00:00.000Z"))
+	assert.Equal(map[string]interface{}{
+		"inceptionDate":            "2005-06-01T00:00:00Z",
+		"inceptionDateEpoch":       int64(1117584000),
+		"inceptionDatePrecision":   monthPrecision,
+		"terminationDate":          "2007-01-01T00:00:00.000Z",
+		"terminationDateEpoch":     int64(1167609600),
+		"terminationDatePrecision": datetimePrecision,
+	}, params)
+}
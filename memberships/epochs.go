@@ -0,0 +1,281 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultEpochBatchSize is the number of memberships RecomputeEpochs reads and fixes at a time
+const DefaultEpochBatchSize = 500
+
+// maxUnparseableListed caps the unparseable dates listed in an EpochReport, though all of them are counted
+const maxUnparseableListed = 1000
+
+// The date properties that have an *Epoch property alongside them
+var (
+	membershipDates = []string{"inceptionDate", "terminationDate", "lastModified"}
+	roleDates       = []string{"inceptionDate", "terminationDate"}
+)
+
+// EpochReport counts the memberships and roles RecomputeEpochs has scanned and fixed so far
+type EpochReport struct {
+	MembershipsScanned int               `json:"membershipsScanned"`
+	RolesScanned       int               `json:"rolesScanned"`
+	MembershipsFixed   int               `json:"membershipsFixed"`
+	RolesFixed         int               `json:"rolesFixed"`
+	UnparseableCount   int               `json:"unparseableCount"`
+	Unparseable        []UnparseableDate `json:"unparseable"`
+}
+
+// UnparseableDate is a date property RecomputeEpochs could not parse, so left without an epoch
+type UnparseableDate struct {
+	UUID     string `json:"uuid"`
+	RoleUUID string `json:"roleUuid,omitempty"`
+	Property string `json:"property"`
+	Value    string `json:"value"`
+}
+
+type epochRoleRow struct {
+	ID       int64                  `json:"id"`
+	RoleUUID string                 `json:"roleUuid"`
+	Dates    map[string]interface{} `json:"dates"`
+}
+
+type epochRow struct {
+	UUID  string                 `json:"uuid"`
+	Dates map[string]interface{} `json:"dates"`
+	Roles []epochRoleRow         `json:"roles"`
+}
+
+// RecomputeEpochs scans the memberships and their HAS_ROLE relationships batchSize memberships at a time,
+// setting the *Epoch property of each date property to the date it parses to. Epochs are removed where there is
+// no date, and left out where the date cannot be parsed. progress, if not nil, is passed the report after each batch.
+func (s cypherStore) RecomputeEpochs(ctx context.Context, batchSize int, progress func(EpochReport)) (EpochReport, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultEpochBatchSize
+	}
+	start := time.Now()
+	report := EpochReport{Unparseable: []UnparseableDate{}}
+	after := ""
+	for {
+		rows := []epochRow{}
+		read := &neoism.CypherQuery{
+			Statement: `// read membership dates
+				MATCH (m:Membership)
+				WHERE m.uuid > $after
+				WITH m ORDER BY m.uuid LIMIT $limit
+				OPTIONAL MATCH (m)-[rr:HAS_ROLE]->(r:Thing)
+				RETURN m.uuid as uuid,
+					m {.inceptionDate, .inceptionDateEpoch, .terminationDate, .terminationDateEpoch, .lastModified, .lastModifiedEpoch} as dates,
+					collect(CASE WHEN rr IS NULL THEN null
+						ELSE {id: id(rr), roleUuid: r.uuid, dates: rr {.inceptionDate, .inceptionDateEpoch, .terminationDate, .terminationDateEpoch}} END) as roles
+				ORDER BY uuid`,
+			Parameters: map[string]interface{}{"after": after, "limit": batchSize},
+			Result:     &rows,
+		}
+		if err := s.read(ctx, []*neoism.CypherQuery{read}); err != nil {
+			return report, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+
+		membershipFixes := []map[string]interface{}{}
+		roleFixes := []map[string]interface{}{}
+		for _, row := range rows {
+			report.MembershipsScanned++
+			fixes, unparseable := epochFixes(row.Dates, membershipDates)
+			if len(fixes) > 0 {
+				membershipFixes = append(membershipFixes, map[string]interface{}{"uuid": row.UUID, "epochs": fixes})
+			}
+			for _, property := range unparseable {
+				report.addUnparseable(UnparseableDate{UUID: row.UUID, Property: property, Value: dateString(row.Dates[property])})
+			}
+
+			for _, role := range row.Roles {
+				report.RolesScanned++
+				fixes, unparseable := epochFixes(role.Dates, roleDates)
+				if len(fixes) > 0 {
+					roleFixes = append(roleFixes, map[string]interface{}{"id": role.ID, "epochs": fixes})
+				}
+				for _, property := range unparseable {
+					report.addUnparseable(UnparseableDate{UUID: row.UUID, RoleUUID: role.RoleUUID, Property: property, Value: dateString(role.Dates[property])})
+				}
+			}
+		}
+
+		if len(membershipFixes) > 0 || len(roleFixes) > 0 {
+			fix := []*neoism.CypherQuery{
+				{
+					Statement: `// fix membership epochs
+						UNWIND $memberships as fix
+						MATCH (m:Membership {uuid: fix.uuid})
+						SET m += fix.epochs`,
+					Parameters: map[string]interface{}{"memberships": membershipFixes},
+				},
+				{
+					Statement: `// fix role epochs
+						UNWIND $roles as fix
+						MATCH ()-[rr:HAS_ROLE]->()
+						WHERE id(rr) = fix.id
+						SET rr += fix.epochs`,
+					Parameters: map[string]interface{}{"roles": roleFixes},
+				},
+			}
+			if err := s.write(ctx, fix); err != nil {
+				return report, err
+			}
+			report.MembershipsFixed += len(membershipFixes)
+			report.RolesFixed += len(roleFixes)
+		}
+
+		logEntry(ctx, "recompute_epochs", "", start).WithFields(log.Fields{
+			"memberships_scanned": report.MembershipsScanned,
+			"memberships_fixed":   report.MembershipsFixed,
+			"roles_fixed":         report.RolesFixed,
+			"unparseable_count":   report.UnparseableCount,
+		}).Info("Recomputed a batch of epochs")
+		if progress != nil {
+			progress(report)
+		}
+		after = rows[len(rows)-1].UUID
+	}
+	return report, nil
+}
+
+func (r *EpochReport) addUnparseable(d UnparseableDate) {
+	r.UnparseableCount++
+	if len(r.Unparseable) < maxUnparseableListed {
+		r.Unparseable = append(r.Unparseable, d)
+	}
+}
+
+// epochFixes returns the epoch properties of dates that need setting to make them match the date properties named,
+// with a nil value for those to remove, and the date properties that could not be parsed
+func epochFixes(dates map[string]interface{}, names []string) (map[string]interface{}, []string) {
+	fixes := map[string]interface{}{}
+	unparseable := []string{}
+	for _, name := range names {
+		epochName := name + "Epoch"
+		current, hasEpoch := asEpoch(dates[epochName])
+		date := dateString(dates[name])
+		if date == "" {
+			if dates[epochName] != nil {
+				fixes[epochName] = nil
+			}
+			continue
+		}
+
+		epoch, err := epochOf(date)
+		if err != nil {
+			unparseable = append(unparseable, name)
+			continue
+		}
+		if !hasEpoch || current != epoch {
+			fixes[epochName] = epoch
+		}
+	}
+	return fixes, unparseable
+}
+
+func dateString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// asEpoch reads an epoch property, which is decoded as a float64 from JSON
+func asEpoch(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// EpochJob runs RecomputeEpochs in the background, one run at a time, and reports on the latest run
+type EpochJob struct {
+	store     cypherStore
+	batchSize int
+
+	mu     sync.Mutex
+	status EpochJobStatus
+}
+
+// EpochJobStatus is the progress of the latest run of an EpochJob, or its outcome once it has finished
+type EpochJobStatus struct {
+	Running    bool        `json:"running"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Report     EpochReport `json:"report"`
+}
+
+// NewEpochJob returns a job that recomputes the epochs in store batchSize memberships at a time
+func NewEpochJob(store cypherStore, batchSize int) *EpochJob {
+	return &EpochJob{store: store, batchSize: batchSize}
+}
+
+// Start starts a run in the background, unless one is already running, returning whether it did
+func (j *EpochJob) Start(ctx context.Context) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Running {
+		return false
+	}
+	now := time.Now().UTC()
+	j.status = EpochJobStatus{Running: true, StartedAt: &now}
+
+	go func() {
+		report, err := j.store.RecomputeEpochs(ctx, j.batchSize, func(report EpochReport) {
+			j.mu.Lock()
+			j.status.Report = report
+			j.mu.Unlock()
+		})
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		finished := time.Now().UTC()
+		j.status.Running = false
+		j.status.FinishedAt = &finished
+		j.status.Report = report
+		if err != nil {
+			j.status.Error = err.Error()
+		}
+	}()
+	return true
+}
+
+// Status returns the status of the latest run
+func (j *EpochJob) Status() EpochJobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status
+}
+
+// Handler starts a run on a POST, responding 202, or 409 if one is already running. Any other request gets the
+// status of the latest run.
+func (j *EpochJob) Handler(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	if r.Method == http.MethodPost {
+		// The run outlives the request, so only the transaction id is kept from its context
+		status = http.StatusAccepted
+		if !j.Start(transactionContext(transactionidutils.GetTransactionIDFromRequest(r))) {
+			status = http.StatusConflict
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(j.Status())
+}
@@ -0,0 +1,72 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpochFixes(t *testing.T) {
+	assert := assert.New(t)
+
+	fixes, unparseable := epochFixes(map[string]interface{}{
+		"inceptionDate":        "2005-01-01T00:00:00Z",
+		"inceptionDateEpoch":   float64(1104537600),
+		"terminationDate":      "2010-01-01T00:00:00Z",
+		"lastModifiedEpoch":    float64(1),
+		"terminationDateEpoch": nil,
+	}, membershipDates)
+	assert.Equal(map[string]interface{}{"terminationDateEpoch": int64(1262304000), "lastModifiedEpoch": nil}, fixes)
+	assert.Empty(unparseable)
+
+	fixes, unparseable = epochFixes(map[string]interface{}{
+		"inceptionDate":      "sometime",
+		"inceptionDateEpoch": float64(1104537600),
+		"terminationDate":    "2010-01-01T00:00:00Z",
+	}, roleDates)
+	assert.Equal(map[string]interface{}{"terminationDateEpoch": int64(1262304000)}, fixes, "An unparseable date's epoch is left as it is")
+	assert.Equal([]string{"inceptionDate"}, unparseable)
+}
+
+func TestEpochReportCapsTheUnparseableListed(t *testing.T) {
+	report := EpochReport{}
+	for i := 0; i < maxUnparseableListed+5; i++ {
+		report.addUnparseable(UnparseableDate{UUID: membershipUUID, Property: "inceptionDate", Value: "2005"})
+	}
+	assert.Equal(t, maxUnparseableListed+5, report.UnparseableCount)
+	assert.Len(t, report.Unparseable, maxUnparseableListed)
+}
+
+func TestEpochJobHandler(t *testing.T) {
+	assert := assert.New(t)
+	job := NewEpochJob(NewCypherStore(fakeConnection{}), 10)
+
+	w := httptest.NewRecorder()
+	job.Handler(w, httptest.NewRequest("POST", "/__admin/recompute-epochs", nil))
+	assert.Equal(http.StatusAccepted, w.Code)
+
+	assert.Eventually(func() bool { return !job.Status().Running }, time.Second, time.Millisecond)
+
+	w = httptest.NewRecorder()
+	job.Handler(w, httptest.NewRequest("GET", "/__admin/recompute-epochs", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	status := EpochJobStatus{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&status))
+	assert.NotNil(status.FinishedAt)
+	assert.Empty(status.Error)
+}
+
+func TestEpochJobRunsOneAtATime(t *testing.T) {
+	job := NewEpochJob(NewCypherStore(fakeConnection{}), 10)
+	job.status.Running = true
+	assert.False(t, job.Start(context.Background()))
+
+	w := httptest.NewRecorder()
+	job.Handler(w, httptest.NewRequest("POST", "/__admin/recompute-epochs", nil))
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
@@ -0,0 +1,132 @@
+package memberships
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultExportPageSize is the number of memberships read from neo4j at a time by an export
+const DefaultExportPageSize = 500
+
+// Export writes every membership, in the shape Read returns, to w as newline delimited JSON.
+// If since is not zero only memberships last modified at or after it are written.
+// Memberships are read pageSize at a time in uuid order, so memory use does not grow with the size of the graph.
+func (s service) Export(ctx context.Context, w io.Writer, since time.Time, pageSize int) (int, error) {
+	if pageSize <= 0 {
+		pageSize = DefaultExportPageSize
+	}
+
+	enc := json.NewEncoder(w)
+	after := ""
+	exported := 0
+	for {
+		page, next, err := s.store.ReadPage(ctx, after, since, pageSize)
+		if err != nil {
+			return exported, err
+		}
+
+		for _, m := range page {
+			if err := enc.Encode(m); err != nil {
+				return exported, err
+			}
+			exported++
+		}
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		if next == "" {
+			return exported, nil
+		}
+		after = next
+	}
+}
+
+// ExportHandler streams the export as newline delimited JSON, gzip compressed if the client accepts it.
+// Memberships modified before the RFC3339 timestamp in ?since= are left out.
+func (s service) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, v); err != nil {
+			writeJSONMessage(w, http.StatusBadRequest, "since must be an RFC3339 timestamp")
+			return
+		}
+	}
+
+	pageSize := DefaultExportPageSize
+	if v := r.URL.Query().Get("pageSize"); v != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(v); err != nil || pageSize <= 0 {
+			writeJSONMessage(w, http.StatusBadRequest, "pageSize must be a positive integer")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = flushingGzipWriter{gz, w}
+	}
+	w.WriteHeader(http.StatusOK)
+
+	start := time.Now()
+	count, err := s.Export(requestContext(r), out, since, pageSize)
+	if err != nil {
+		// The status has already been sent, so all we can do is stop the stream short
+		logEntry(requestContext(r), "export", "", start).WithError(err).WithField("exported", count).Error("Export failed")
+		return
+	}
+	logEntry(requestContext(r), "export", "", start).WithField("exported", count).Info("Export completed")
+}
+
+// acceptsEncoding is true if the Accept-Encoding header gives the coding, or failing that *, a quality above zero
+func acceptsEncoding(acceptEncoding string, coding string) bool {
+	quality, specificity := 0.0, -1
+	for _, accepted := range strings.Split(acceptEncoding, ",") {
+		name, params, err := mime.ParseMediaType(accepted)
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch name {
+		case coding:
+			s = 1
+		case "*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, quality = s, 1
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			quality = q
+		}
+	}
+	return quality > 0
+}
+
+// flushingGzipWriter flushes compressed pages through to the client as the export writes them
+type flushingGzipWriter struct {
+	*gzip.Writer
+	w http.ResponseWriter
+}
+
+func (g flushingGzipWriter) Flush() {
+	g.Writer.Flush()
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,87 @@
+//go:build !jenkins
+// +build !jenkins
+
+package memberships
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportWritesMembershipsAsReadReturnsThem(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	expected, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+
+	buf := &bytes.Buffer{}
+	count, err := membershipDriver.Export(context.Background(), buf, time.Now().Add(-time.Minute), 1)
+	assert.NoError(err)
+	assert.True(count >= 1)
+
+	exported := exportedMemberships(t, buf)
+	assert.Len(exported, count)
+	assert.Contains(exported, expected)
+}
+
+func TestExportLeavesOutMembershipsModifiedBeforeSince(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	buf := &bytes.Buffer{}
+	_, err := membershipDriver.Export(context.Background(), buf, time.Now().Add(time.Hour), DefaultExportPageSize)
+	assert.NoError(err)
+
+	for _, m := range exportedMemberships(t, buf) {
+		assert.NotEqual(membershipUUID, m.UUID)
+	}
+}
+
+func TestReadPageReadsAMembershipWithTwoOrganisationsOnce(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	secondOrganisation := &neoism.CypherQuery{
+		Statement: `MATCH (m:Membership {uuid:$uuid})
+			MERGE (o:Thing {uuid:$orguuid})
+			CREATE (m)-[:HAS_ORGANISATION]->(o)`,
+		Parameters: map[string]interface{}{"uuid": membershipUUID, "orguuid": newOrgUUID},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{secondOrganisation}))
+
+	// Starts just before membershipUUID, so that it is the first membership of the page
+	after := membershipUUID[:len(membershipUUID)-1]
+	page, next, err := NewCypherStore(db).ReadPage(context.Background(), after, time.Time{}, 1)
+	assert.NoError(err)
+	if assert.Len(page, 1) {
+		assert.Equal(membershipUUID, page[0].UUID)
+		assert.Equal(newOrgUUID, page[0].OrganisationUUID, "The organisation with the lowest uuid is read")
+	}
+	assert.Equal(membershipUUID, next, "A full page is followed by another")
+
+	page, _, err = NewCypherStore(db).ReadPage(context.Background(), after, time.Time{}, 10)
+	assert.NoError(err)
+	count := 0
+	for _, m := range page {
+		if m.UUID == membershipUUID {
+			count++
+		}
+	}
+	assert.Equal(1, count)
+}
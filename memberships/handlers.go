@@ -0,0 +1,111 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+)
+
+// The handlers below serve the same API as baseftrwapp, but pass the request's context on to the store,
+// so that the trace of a request continues through to the Cypher statements it runs.
+// They read the uuid from the path pattern they are registered with, e.g. "GET /memberships/{uuid}".
+
+// ReadHandler responds with the membership, or 404 if there is none with the uuid. It is sent as JSON-LD if the
+// Accept header prefers that to JSON.
+func (s service) ReadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Vary", "Accept")
+	if negotiate(r.Header.Get("Accept"), "application/json", JSONLDType) == JSONLDType {
+		s.readLinkedHandler(w, r)
+		return
+	}
+
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	m, found, err := s.store.Read(ctx, uuid)
+	if err != nil {
+		logEntry(ctx, "read", uuid, start).WithError(err).Error("Could not read membership")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Membership not found.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(m)
+}
+
+// WriteHandler writes the membership in the body, which must have the uuid in the path
+func (s service) WriteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	thing, id, err := s.DecodeJSON(json.NewDecoder(r.Body))
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if id != uuid {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Uuids from payload and request, respectively, do not match: '%v' '%v'", id, uuid))
+		return
+	}
+
+	if err := s.store.Write(ctx, thing.(membership)); err != nil {
+		logEntry(ctx, "write", uuid, start).WithError(err).Error("Could not write membership")
+		if strings.HasSuffix(neoErrorType(err), "ConstraintValidationFailed") || err == ErrConflict || err == ErrLowerPrioritySource {
+			writeJSONMessage(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteHandler deletes the membership, responding 404 if there is none with the uuid
+func (s service) DeleteHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	found, err := s.store.Delete(ctx, uuid)
+	if err != nil {
+		logEntry(ctx, "delete", uuid, start).WithError(err).Error("Could not delete membership")
+		if err == ErrConflict || err == ErrLowerPrioritySource {
+			writeJSONMessage(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Membership not found.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CountHandler responds with the number of memberships
+func (s service) CountHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	count, err := s.store.Count(ctx)
+	if err != nil {
+		logEntry(ctx, "count", "", start).WithError(err).Error("Could not count memberships")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(count)
+}
+
+// requestContext adds the request's transaction id to its context
+func requestContext(r *http.Request) context.Context {
+	return transactionidutils.TransactionAwareContext(r.Context(), transactionidutils.GetTransactionIDFromRequest(r))
+}
@@ -0,0 +1,228 @@
+// The handlers read their path wildcards with PathValue, which needs the Go 1.22 mux that GOPATH builds leave off
+//go:debug httpmuxgo121=0
+
+package memberships
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newTestMux(s service) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /memberships/{uuid}", s.ReadHandler)
+	mux.HandleFunc("PUT /memberships/{uuid}", s.WriteHandler)
+	mux.HandleFunc("DELETE /memberships/{uuid}", s.DeleteHandler)
+	mux.HandleFunc("PATCH /memberships/{uuid}", s.PatchHandler)
+	mux.HandleFunc("GET /memberships/{uuid}/roles/{roleUuid}", s.ReadRoleHandler)
+	mux.HandleFunc("PUT /memberships/{uuid}/roles/{roleUuid}", s.WriteRoleHandler)
+	mux.HandleFunc("DELETE /memberships/{uuid}/roles/{roleUuid}", s.DeleteRoleHandler)
+	mux.HandleFunc("GET /memberships/__count", s.CountHandler)
+	return mux
+}
+
+func serve(h http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestHandlersServeTheBaseRWAPI(t *testing.T) {
+	assert := assert.New(t)
+	mux := newTestMux(NewMembershipService(NewMemoryStore()))
+	body, _ := json.Marshal(fullMembership)
+
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", "/memberships/"+newOrgUUID, string(body)).Code, "Uuids do not match")
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", "/memberships/"+membershipUUID, "{").Code)
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", "/memberships/"+membershipUUID, `{"uuid":"`+membershipUUID+`"}`).Code, "Does not match the schema")
+	assert.Equal(http.StatusOK, serve(mux, "PUT", "/memberships/"+membershipUUID, string(body)).Code)
+
+	w := serve(mux, "GET", "/memberships/"+membershipUUID, "")
+	assert.Equal(http.StatusOK, w.Code)
+	read := membership{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&read))
+	assert.Equal(fullMembership.PrefLabel, read.PrefLabel)
+
+	assert.Equal("1\n", serve(mux, "GET", "/memberships/__count", "").Body.String())
+
+	assert.Equal(http.StatusNoContent, serve(mux, "DELETE", "/memberships/"+membershipUUID, "").Code)
+	assert.Equal(http.StatusNotFound, serve(mux, "DELETE", "/memberships/"+membershipUUID, "").Code)
+	assert.Equal(http.StatusNotFound, serve(mux, "GET", "/memberships/"+membershipUUID, "").Code)
+}
+
+func TestReadGivesEachRoleTheDatesOfItsLatestPeriod(t *testing.T) {
+	assert := assert.New(t)
+	mux := newTestMux(NewMembershipService(NewMemoryStore()))
+	m := fullMembership
+	m.MembershipRoles = []role{{roleUUID, []period{{"2006-01-01T00:00:00.000Z", "2006-09-01T00:00:00.000Z"}, {"2006-10-01T00:00:00.000Z", "2007-01-01T00:00:00.000Z"}}}}
+	body, _ := json.Marshal(m)
+	assert.Equal(http.StatusOK, serve(mux, "PUT", "/memberships/"+membershipUUID, string(body)).Code)
+
+	read := struct {
+		MembershipRoles []map[string]interface{} `json:"membershipRoles"`
+	}{}
+	assert.NoError(json.NewDecoder(serve(mux, "GET", "/memberships/"+membershipUUID, "").Body).Decode(&read))
+	assert.Len(read.MembershipRoles, 1)
+	assert.Equal(roleUUID, read.MembershipRoles[0]["roleuuid"])
+	assert.Equal("2006-10-01T00:00:00.000Z", read.MembershipRoles[0]["inceptionDate"], "Old clients read the role's dates")
+	assert.Equal("2007-01-01T00:00:00.000Z", read.MembershipRoles[0]["terminationDate"])
+	assert.Len(read.MembershipRoles[0]["periods"], 2)
+}
+
+func TestReadContinuesTheRequestTraceThroughToNeo4j(t *testing.T) {
+	assert := assert.New(t)
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	mux := newTestMux(NewMembershipService(NewCypherStore(fakeConnection{})))
+	h := otelhttp.NewHandler(mux, "read membership")
+
+	req := httptest.NewRequest("GET", "/memberships/"+membershipUUID, nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("X-Request-Id", "tid_traced")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	assert.Len(spans, 3, "Request, transaction and statement spans")
+	for _, span := range spans {
+		assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext.TraceID().String())
+	}
+
+	statement := spans[0]
+	assert.Equal("read membership", statement.Name)
+	assert.Contains(statement.Attributes, tracing.TransactionIDKey.String("tid_traced"))
+	assert.Equal(spans[1].SpanContext.SpanID(), statement.Parent.SpanID(), "Statement is part of the transaction")
+}
+
+func TestPatchHandler(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMembershipService(NewMemoryStore())
+	mux := newTestMux(s)
+	patch := func(contentType string, uuid string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("PATCH", "/memberships/"+uuid, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(http.StatusNotFound, patch(MergePatchType, membershipUUID, `{"prefLabel":"Patched"}`).Code)
+	assert.NoError(s.Write(fullMembership, "TRANS_ID"))
+
+	w := patch(MergePatchType+"; charset=UTF-8", membershipUUID, `{"prefLabel":"Patched"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	patched := membership{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&patched))
+	assert.Equal("Patched", patched.PrefLabel)
+
+	assert.Equal(http.StatusOK, patch(JSONPatchType, membershipUUID, `[{"op":"remove","path":"/terminationDate"}]`).Code)
+	assert.Equal(http.StatusUnsupportedMediaType, patch("application/json", membershipUUID, `{"prefLabel":"Patched"}`).Code)
+	assert.Equal(http.StatusBadRequest, patch(MergePatchType, membershipUUID, `{`).Code)
+	assert.Equal(http.StatusBadRequest, patch(JSONPatchType, membershipUUID, `[{"op":"remove","path":"/terminationDate"}]`).Code, "Nothing to remove")
+	assert.Equal(http.StatusUnprocessableEntity, patch(MergePatchType, membershipUUID, `{"uuid":"`+newOrgUUID+`"}`).Code)
+	assert.Equal(http.StatusUnprocessableEntity, patch(MergePatchType, membershipUUID, `{"inceptionDate":"yesterday"}`).Code)
+	assert.Equal(http.StatusUnprocessableEntity, patch(MergePatchType, membershipUUID, `{"colour":"red"}`).Code)
+}
+
+func TestPatchRepairsADateThatDoesNotParse(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryStore()
+	mux := newTestMux(NewMembershipService(store))
+	patch := func(body string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("PATCH", "/memberships/"+membershipUUID, strings.NewReader(body))
+		req.Header.Set("Content-Type", MergePatchType)
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// As written before dates were checked
+	written := fullMembership
+	written.InceptionDate = "value1"
+	store.memberships[membershipUUID] = written
+
+	assert.Equal(http.StatusUnprocessableEntity, patch(`{"prefLabel":"Patched"}`), "The patch keeps the date")
+	assert.Equal(http.StatusOK, patch(`{"inceptionDate":"2005-06"}`))
+	m, _, err := store.Read(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.Equal("2005-06", m.InceptionDate)
+}
+
+func TestRoleHandlers(t *testing.T) {
+	assert := assert.New(t)
+	s := NewMembershipService(NewMemoryStore())
+	mux := newTestMux(s)
+	rolePath := "/memberships/" + membershipUUID + "/roles/" + newOrgUUID
+
+	assert.Equal(http.StatusNotFound, serve(mux, "PUT", rolePath, `{"inceptionDate":"2008-01-01T00:00:00Z"}`).Code, "No membership")
+	assert.NoError(s.Write(fullMembership, "TRANS_ID"))
+
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", rolePath, `{`).Code)
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", rolePath, `{"roleuuid":"`+roleUUID+`"}`).Code, "Role uuids do not match")
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", rolePath, `{"inceptionDate":"2008-13"}`).Code)
+	assert.Equal(http.StatusNotFound, serve(mux, "GET", rolePath, "").Code)
+	assert.Equal(http.StatusOK, serve(mux, "PUT", rolePath, `{"inceptionDate":"2008-01-01T00:00:00Z"}`).Code)
+
+	w := serve(mux, "GET", rolePath, "")
+	assert.Equal(http.StatusOK, w.Code)
+	read := role{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&read))
+	assert.Equal(role{RoleUUID: newOrgUUID, Periods: []period{{InceptionDate: "2008-01-01T00:00:00Z"}}}, read)
+
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", rolePath, `{"periods":[{"inceptionDate":"2008-01-01T00:00:00Z"},{"inceptionDate":"2010-01-01T00:00:00Z"}]}`).Code, "The first period is ongoing")
+	assert.Equal(http.StatusOK, serve(mux, "PUT", rolePath, `{"periods":[{"inceptionDate":"2012-01-01T00:00:00Z"},{"inceptionDate":"2008-01-01T00:00:00Z","terminationDate":"2010-01-01T00:00:00Z"}]}`).Code)
+	w = serve(mux, "GET", rolePath, "")
+	read = role{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&read))
+	assert.Equal([]period{{"2008-01-01T00:00:00Z", "2010-01-01T00:00:00Z"}, {"2012-01-01T00:00:00Z", ""}}, read.Periods, "Periods are in order")
+
+	assert.Equal(http.StatusNoContent, serve(mux, "DELETE", rolePath, "").Code)
+	assert.Equal(http.StatusNotFound, serve(mux, "DELETE", rolePath, "").Code)
+	assert.Equal(http.StatusOK, serve(mux, "GET", "/memberships/"+membershipUUID+"/roles/"+roleUUID, "").Code, "Other roles are left as they were")
+}
+
+func TestWriteHandlerRejectsALowerPrioritySource(t *testing.T) {
+	assert := assert.New(t)
+	store := EnforceSourcePolicy(NewMemoryStore(), SourcePolicy{Priority: []string{"MANUAL", "FACTSET"}})
+	mux := newTestMux(NewMembershipService(store))
+	manual, _ := json.Marshal(sourcedBy("MANUAL"))
+	factset, _ := json.Marshal(sourcedBy("FACTSET"))
+
+	assert.Equal(http.StatusOK, serve(mux, "PUT", "/memberships/"+membershipUUID, string(manual)).Code)
+	assert.Equal(http.StatusConflict, serve(mux, "PUT", "/memberships/"+membershipUUID, string(factset)).Code)
+	assert.Equal(http.StatusBadRequest, serve(mux, "PUT", "/memberships/"+membershipUUID, `{"uuid":"`+membershipUUID+`","source":{}}`).Code, "No authority")
+}
+
+func TestExportHandlerGzipsOnlyForAnAcceptedGzip(t *testing.T) {
+	s := NewMembershipService(NewMemoryStore())
+	for acceptEncoding, gzipped := range map[string]bool{
+		"":                    false,
+		"gzip":                true,
+		"deflate, GZIP":       true,
+		"gzip;q=0":            false,
+		"gzip;q=0, *":         false,
+		"*":                   true,
+		"*;q=0.5, gzip;q=0.0": false,
+		"identity, *;q=0":     false,
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/memberships/__export", nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		s.ExportHandler(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, gzipped, w.Header().Get("Content-Encoding") == "gzip", "Accept-Encoding: %s", acceptEncoding)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	}
+}
@@ -0,0 +1,229 @@
+package memberships
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+const healthCheckSentinelLabel = "MembershipsRWHealthCheck"
+
+// HealthChecker runs the checks on the membership data and schema that back the health endpoint
+type HealthChecker struct {
+	store               cypherStore
+	latencyThreshold    time.Duration
+	countDropThreshold  float64
+	countBaselineWindow time.Duration
+
+	mu             sync.Mutex
+	lastCount      int
+	lastCountKnown bool
+	lastCountAt    time.Time
+}
+
+// NewHealthChecker returns a HealthChecker that fails when a query takes longer than latencyThreshold,
+// or when the membership count drops by more than countDropPercent since the last healthy check. A drop fails the
+// check for countBaselineWindow, after which the lower count becomes the baseline. Zero keeps failing until the count
+// recovers or the service restarts.
+func NewHealthChecker(s cypherStore, latencyThreshold time.Duration, countDropPercent int, countBaselineWindow time.Duration) *HealthChecker {
+	return &HealthChecker{
+		store:               s,
+		latencyThreshold:    latencyThreshold,
+		countDropThreshold:  float64(countDropPercent) / 100,
+		countBaselineWindow: countBaselineWindow,
+	}
+}
+
+const (
+	indexFormat      = ":%s(%s)"
+	constraintFormat = ":%s).*.%s.*ISUNIQUE"
+)
+
+// schemaRow is a row of db.indexes() or db.constraints(). Neo4j 3 describes each index and constraint,
+// Neo4j 4 only describes constraints, listing the labels and properties of an index instead.
+type schemaRow struct {
+	Description   string   `json:"description"`
+	LabelsOrTypes []string `json:"labelsOrTypes"`
+	Properties    []string `json:"properties"`
+}
+
+func (r schemaRow) describe() string {
+	if r.Description == "" && len(r.LabelsOrTypes) == 1 && len(r.Properties) == 1 {
+		return fmt.Sprintf("INDEX ON :%s(%s)", r.LabelsOrTypes[0], r.Properties[0])
+	}
+	return r.Description
+}
+
+// CheckSchema verifies that the indexes and constraints created by Initialise are present
+func (h *HealthChecker) CheckSchema() (string, error) {
+	indexDescriptions, constraintDescriptions, err := h.store.readSchema()
+	if err != nil {
+		return "", err
+	}
+
+	missing := append(
+		missingSchema(indexDescriptions, indexes, indexFormat, "index"),
+		missingSchema(constraintDescriptions, constraints, constraintFormat, "constraint")...,
+	)
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return fmt.Sprintf("%d indexes and %d constraints present", len(indexes), len(constraints)), nil
+}
+
+// readSchema returns the descriptions of the indexes and constraints the graph has. They are read on the direct
+// connection, which Migrate creates them on.
+func (s cypherStore) readSchema() ([]string, []string, error) {
+	indexResults := []schemaRow{}
+	constraintResults := []schemaRow{}
+
+	queries := []*neoism.CypherQuery{
+		{
+			Statement: `CALL db.indexes()`,
+			Result:    &indexResults,
+		},
+		{
+			Statement: `CALL db.constraints()`,
+			Result:    &constraintResults,
+		},
+	}
+	if err := s.writeDirect(context.Background(), queries); err != nil {
+		return nil, nil, err
+	}
+
+	var indexDescriptions, constraintDescriptions []string
+	for _, r := range indexResults {
+		indexDescriptions = append(indexDescriptions, r.describe())
+	}
+	for _, r := range constraintResults {
+		constraintDescriptions = append(constraintDescriptions, r.describe())
+	}
+	return indexDescriptions, constraintDescriptions, nil
+}
+
+// missingSchema returns the label/property pairs in expected with no matching description.
+// Descriptions are compared with whitespace removed, format must have a %s for the label and one for the property,
+// and may contain .* to skip the variable name Neo4j puts in constraint descriptions.
+func missingSchema(descriptions []string, expected map[string]string, format string, kind string) []string {
+	var missing []string
+	for label, property := range expected {
+		parts := strings.Split(fmt.Sprintf(format, label, property), ".*")
+		found := false
+		for _, d := range descriptions {
+			if containsInOrder(strings.Join(strings.Fields(d), ""), parts) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, fmt.Sprintf("%s on :%s(%s)", kind, label, property))
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func containsInOrder(s string, parts []string) bool {
+	for _, p := range parts {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return true
+}
+
+// CheckRoundTrip writes a value to a sentinel node and reads it back in a separate statement
+func (h *HealthChecker) CheckRoundTrip() (string, error) {
+	value := time.Now().UTC().Format(time.RFC3339Nano)
+
+	write := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MERGE (s:%s {id:'memberships-rw-neo4j'}) SET s.value = $value`, healthCheckSentinelLabel),
+		Parameters: map[string]interface{}{
+			"value": value,
+		},
+	}
+
+	results := []struct {
+		Value string `json:"value"`
+	}{}
+	read := &neoism.CypherQuery{
+		Statement: fmt.Sprintf(`MATCH (s:%s {id:'memberships-rw-neo4j'}) RETURN s.value as value`, healthCheckSentinelLabel),
+		Result:    &results,
+	}
+
+	if err := h.store.conn.CypherBatch([]*neoism.CypherQuery{write}); err != nil {
+		return "", fmt.Errorf("could not write sentinel node: %v", err)
+	}
+	if err := h.store.conn.CypherBatch([]*neoism.CypherQuery{read}); err != nil {
+		return "", fmt.Errorf("could not read sentinel node: %v", err)
+	}
+	if len(results) == 0 || results[0].Value != value {
+		return "", fmt.Errorf("sentinel node did not return the value written to it")
+	}
+	return "sentinel node written and read back", nil
+}
+
+// CheckCount fails if the membership count has dropped by more than the threshold since the last healthy check.
+// The baseline is kept until the count recovers, the service restarts, or the baseline window has passed since the
+// last healthy check.
+func (h *HealthChecker) CheckCount() (string, error) {
+	start := time.Now()
+	count, err := h.store.Count(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lastCountKnown && countDropped(h.lastCount, count, h.countDropThreshold) {
+		fields := log.Fields{"last_count": h.lastCount, "count": count}
+		if h.countBaselineWindow <= 0 || time.Since(h.lastCountAt) < h.countBaselineWindow {
+			logEntry(context.Background(), "check_count", "", start).WithFields(fields).Warn("Membership count dropped")
+			return "", fmt.Errorf("membership count dropped from %d to %d", h.lastCount, count)
+		}
+		logEntry(context.Background(), "check_count", "", start).WithFields(fields).Warn("Membership count stayed down for the baseline window, resetting the baseline")
+	}
+
+	h.lastCount = count
+	h.lastCountKnown = true
+	h.lastCountAt = time.Now()
+	return fmt.Sprintf("%d memberships", count), nil
+}
+
+func countDropped(last int, current int, threshold float64) bool {
+	if last == 0 || current >= last {
+		return false
+	}
+	return float64(last-current)/float64(last) > threshold
+}
+
+// CheckLatency fails if a single membership lookup takes longer than the threshold
+func (h *HealthChecker) CheckLatency() (string, error) {
+	results := []struct {
+		UUID string `json:"uuid"`
+	}{}
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (m:Membership) RETURN m.uuid as uuid LIMIT 1`,
+		Result:    &results,
+	}
+
+	start := time.Now()
+	if err := h.store.read(context.Background(), []*neoism.CypherQuery{query}); err != nil {
+		return "", err
+	}
+	elapsed := time.Since(start)
+
+	if elapsed > h.latencyThreshold {
+		return "", fmt.Errorf("query took %v, threshold is %v", elapsed, h.latencyThreshold)
+	}
+	return fmt.Sprintf("query took %v", elapsed), nil
+}
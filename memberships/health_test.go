@@ -0,0 +1,102 @@
+package memberships
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMissingSchemaMatchesNeo4jDescriptions(t *testing.T) {
+	indexDescriptions := []string{
+		"INDEX ON :Identifier(value)",
+		"INDEX ON :FactsetIdentifier(value)",
+	}
+	constraintDescriptions := []string{
+		"CONSTRAINT ON ( thing:Thing ) ASSERT thing.uuid IS UNIQUE",
+		"CONSTRAINT ON ( concept:Concept ) ASSERT concept.uuid IS UNIQUE",
+		"CONSTRAINT ON ( membership:Membership ) ASSERT membership.uuid IS UNIQUE",
+		"CONSTRAINT ON ( factsetidentifier:FactsetIdentifier ) ASSERT factsetidentifier.value IS UNIQUE",
+		"CONSTRAINT ON ( uppidentifier:UPPIdentifier ) ASSERT uppidentifier.value IS UNIQUE",
+	}
+
+	assert.Empty(t, missingSchema(indexDescriptions, indexes, indexFormat, "index"))
+	assert.Empty(t, missingSchema(constraintDescriptions, constraints, constraintFormat, "constraint"))
+}
+
+func TestMissingSchemaMatchesNeo4j4Rows(t *testing.T) {
+	indexRows := []schemaRow{
+		{LabelsOrTypes: []string{"Identifier"}, Properties: []string{"value"}},
+	}
+	constraintDescriptions := []string{
+		"CONSTRAINT ON ( thing:Thing ) ASSERT (thing.uuid) IS UNIQUE",
+		"CONSTRAINT ON ( concept:Concept ) ASSERT (concept.uuid) IS UNIQUE",
+		"CONSTRAINT ON ( membership:Membership ) ASSERT (membership.uuid) IS UNIQUE",
+		"CONSTRAINT ON ( factsetidentifier:FactsetIdentifier ) ASSERT (factsetidentifier.value) IS UNIQUE",
+		"CONSTRAINT ON ( uppidentifier:UPPIdentifier ) ASSERT (uppidentifier.value) IS UNIQUE",
+	}
+
+	assert.Empty(t, missingSchema([]string{indexRows[0].describe()}, indexes, indexFormat, "index"))
+	assert.Empty(t, missingSchema(constraintDescriptions, constraints, constraintFormat, "constraint"))
+}
+
+func TestMissingSchemaReportsAbsentEntries(t *testing.T) {
+	constraintDescriptions := []string{
+		"CONSTRAINT ON ( thing:Thing ) ASSERT thing.uuid IS UNIQUE",
+		"CONSTRAINT ON ( membership:Membership ) ASSERT membership.prefLabel IS UNIQUE",
+	}
+
+	missing := missingSchema(constraintDescriptions, map[string]string{"Thing": "uuid", "Membership": "uuid"}, constraintFormat, "constraint")
+	assert.Equal(t, []string{"constraint on :Membership(uuid)"}, missing)
+	assert.Equal(t, []string{"index on :Identifier(value)"}, missingSchema([]string{"INDEX ON :FactsetIdentifier(value)"}, indexes, indexFormat, "index"))
+}
+
+func TestCountDropped(t *testing.T) {
+	assert := assert.New(t)
+	assert.False(countDropped(0, 0, 0.1), "No baseline")
+	assert.False(countDropped(100, 120, 0.1), "Count grew")
+	assert.False(countDropped(100, 90, 0.1), "Drop at threshold")
+	assert.True(countDropped(100, 89, 0.1), "Drop above threshold")
+	assert.True(countDropped(100, 0, 0.1), "Everything gone")
+}
+
+// countConnection counts however many memberships count points at
+type countConnection struct {
+	fakeConnection
+	count *int
+}
+
+func (c countConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	for _, q := range queries {
+		json.Unmarshal([]byte(fmt.Sprintf(`[{"c":%d}]`, *c.count)), q.Result)
+	}
+	return nil
+}
+
+func TestCheckCountResetsTheBaselineAfterTheWindow(t *testing.T) {
+	assert := assert.New(t)
+	count := 100
+	h := NewHealthChecker(NewCypherStore(countConnection{count: &count}), time.Second, 10, time.Hour)
+
+	_, err := h.CheckCount()
+	assert.NoError(err)
+	count = 50
+	_, err = h.CheckCount()
+	assert.EqualError(err, "membership count dropped from 100 to 50")
+
+	h.lastCountAt = time.Now().Add(-time.Hour)
+	_, err = h.CheckCount()
+	assert.NoError(err, "The drop has lasted the window")
+	count = 45
+	_, err = h.CheckCount()
+	assert.NoError(err, "The baseline is now 50")
+
+	h.countBaselineWindow = 0
+	h.lastCountAt = time.Now().Add(-time.Hour)
+	count = 10
+	_, err = h.CheckCount()
+	assert.Error(err, "Without a window the baseline is kept")
+}
@@ -0,0 +1,185 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultIntegrityViolationLimit = 100
+
+// IntegrityReport lists the membership invariants that were checked and the violations found for each
+type IntegrityReport struct {
+	CheckedAt time.Time              `json:"checkedAt"`
+	Healthy   bool                   `json:"healthy"`
+	Checks    []IntegrityCheckResult `json:"checks"`
+}
+
+// IntegrityCheckResult holds the total number of violations of one invariant and a sample of them
+type IntegrityCheckResult struct {
+	Name           string               `json:"name"`
+	Description    string               `json:"description"`
+	ViolationCount int                  `json:"violationCount"`
+	Violations     []IntegrityViolation `json:"violations"`
+}
+
+// IntegrityViolation identifies the membership that breaks an invariant
+type IntegrityViolation struct {
+	UUID   string `json:"uuid"`
+	Detail string `json:"detail,omitempty"`
+}
+
+type integrityCheck struct {
+	name        string
+	description string
+	statement   string
+}
+
+// Each statement must collect its violations as {uuid, detail} maps into a list named violations
+var integrityChecks = []integrityCheck{
+	{
+		name:        "missing-organisation",
+		description: "Membership has no HAS_ORGANISATION relationship",
+		statement: `
+			MATCH (m:Membership)
+			WHERE NOT (m)-[:HAS_ORGANISATION]->()
+			WITH collect({uuid:m.uuid, detail:''}) as violations`,
+	},
+	{
+		name:        "multiple-members",
+		description: "Membership has more than one HAS_MEMBER relationship",
+		statement: `
+			MATCH (m:Membership)-[rel:HAS_MEMBER]->()
+			WITH m, count(rel) as members
+			WHERE members > 1
+			WITH collect({uuid:m.uuid, detail:toString(members) + ' HAS_MEMBER relationships'}) as violations`,
+	},
+	{
+		// A membership has a HAS_ROLE relationship for each period a role was held, which may start when another ends
+		name:        "overlapping-role-periods",
+		description: "Membership has HAS_ROLE relationships to the same role with periods that overlap",
+		statement: `
+			MATCH (m:Membership)-[rr:HAS_ROLE]->(r:Thing)<-[other:HAS_ROLE]-(m)
+			WHERE id(rr) < id(other)
+				AND coalesce(rr.inceptionDateEpoch, -9223372036854775807) < coalesce(other.terminationDateEpoch, 9223372036854775807)
+				AND coalesce(other.inceptionDateEpoch, -9223372036854775807) < coalesce(rr.terminationDateEpoch, 9223372036854775807)
+			WITH m, r, count(other) as overlaps
+			WITH collect({uuid:m.uuid, detail:'role ' + r.uuid + ' has ' + toString(overlaps) + ' pairs of overlapping periods'}) as violations`,
+	},
+	{
+		name:        "missing-membership-epochs",
+		description: "Membership has a date without the matching epoch property",
+		statement: `
+			MATCH (m:Membership)
+			WHERE (exists(m.inceptionDate) AND NOT exists(m.inceptionDateEpoch))
+				OR (exists(m.terminationDate) AND NOT exists(m.terminationDateEpoch))
+			WITH collect({uuid:m.uuid, detail:'inceptionDate=' + coalesce(m.inceptionDate, '') + ' terminationDate=' + coalesce(m.terminationDate, '')}) as violations`,
+	},
+	{
+		name:        "missing-role-epochs",
+		description: "HAS_ROLE relationship has a date without the matching epoch property",
+		statement: `
+			MATCH (m:Membership)-[rr:HAS_ROLE]->(r:Thing)
+			WHERE (exists(rr.inceptionDate) AND NOT exists(rr.inceptionDateEpoch))
+				OR (exists(rr.terminationDate) AND NOT exists(rr.terminationDateEpoch))
+			WITH collect({uuid:m.uuid, detail:'role ' + r.uuid + ' inceptionDate=' + coalesce(rr.inceptionDate, '') + ' terminationDate=' + coalesce(rr.terminationDate, '')}) as violations`,
+	},
+	{
+		name:        "shared-identifiers",
+		description: "Identifier of a membership also identifies other Things",
+		statement: `
+			MATCH (i:Identifier)-[:IDENTIFIES]->(m:Membership)
+			MATCH (i)-[:IDENTIFIES]->(t:Thing)
+			WITH m, i, collect(DISTINCT t.uuid) as things
+			WHERE size(things) > 1
+			WITH collect({uuid:m.uuid, detail:'identifier ' + i.value + ' identifies ' + toString(size(things)) + ' things'}) as violations`,
+	},
+}
+
+// CheckIntegrity scans the Membership nodes for broken invariants, returning at most limit violations per check
+func (s cypherStore) CheckIntegrity(ctx context.Context, limit int) (IntegrityReport, error) {
+	start := time.Now()
+	if limit <= 0 {
+		limit = defaultIntegrityViolationLimit
+	}
+
+	type checkResult struct {
+		Count      int                  `json:"count"`
+		Violations []IntegrityViolation `json:"violations"`
+	}
+
+	results := make([][]checkResult, len(integrityChecks))
+	queries := make([]*neoism.CypherQuery, len(integrityChecks))
+	for i, check := range integrityChecks {
+		queries[i] = &neoism.CypherQuery{
+			Statement: check.statement + `
+			RETURN size(violations) as count, violations[0..$limit] as violations`,
+			Parameters: map[string]interface{}{
+				"limit": limit,
+			},
+			Result: &results[i],
+		}
+	}
+
+	report := IntegrityReport{CheckedAt: time.Now().UTC(), Healthy: true}
+	if err := s.read(ctx, queries); err != nil {
+		return report, err
+	}
+
+	for i, check := range integrityChecks {
+		result := IntegrityCheckResult{
+			Name:        check.name,
+			Description: check.description,
+			Violations:  []IntegrityViolation{},
+		}
+		if len(results[i]) > 0 {
+			result.ViolationCount = results[i][0].Count
+			result.Violations = append(result.Violations, results[i][0].Violations...)
+		}
+		if result.ViolationCount > 0 {
+			report.Healthy = false
+			logEntry(ctx, "check_integrity", "", start).WithFields(log.Fields{"check": check.name, "violation_count": result.ViolationCount}).Warn("Membership integrity check failed")
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	return report, nil
+}
+
+// IntegrityHandler serves the integrity report as JSON, the number of violations listed per check can be set with ?limit=
+func (s cypherStore) IntegrityHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultIntegrityViolationLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeJSONMessage(w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	start := time.Now()
+	ctx := requestContext(r)
+	report, err := s.CheckIntegrity(ctx, limit)
+	if err != nil {
+		logEntry(ctx, "check_integrity", "", start).WithError(err).Error("Error checking membership integrity")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logEntry(ctx, "check_integrity", "", start).WithError(err).Error("Error encoding integrity report")
+	}
+}
+
+func writeJSONMessage(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg})
+}
@@ -0,0 +1,132 @@
+//go:build !jenkins
+// +build !jenkins
+
+package memberships
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntegrityReportsMembershipWithoutOrganisation(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	report, err := NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.NotContains(violationUUIDs(report, "missing-organisation"), membershipUUID)
+
+	removeOrg := &neoism.CypherQuery{
+		Statement:  `MATCH (m:Membership {uuid:$uuid})-[rel:HAS_ORGANISATION]->() DELETE rel`,
+		Parameters: map[string]interface{}{"uuid": membershipUUID},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{removeOrg}))
+
+	report, err = NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.False(report.Healthy)
+	assert.Contains(violationUUIDs(report, "missing-organisation"), membershipUUID)
+}
+
+func TestIntegrityReportsMissingEpochs(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	removeEpochs := &neoism.CypherQuery{
+		Statement: `MATCH (m:Membership {uuid:$uuid})-[rr:HAS_ROLE]->()
+			REMOVE m.inceptionDateEpoch, rr.terminationDateEpoch`,
+		Parameters: map[string]interface{}{"uuid": membershipUUID},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{removeEpochs}))
+
+	report, err := NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.Contains(violationUUIDs(report, "missing-membership-epochs"), membershipUUID)
+	assert.Contains(violationUUIDs(report, "missing-role-epochs"), membershipUUID)
+}
+
+func TestRecomputeEpochsFixesMissingEpochs(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	breakEpochs := &neoism.CypherQuery{
+		Statement: `MATCH (m:Membership {uuid:$uuid})-[rr:HAS_ROLE]->()
+			REMOVE m.inceptionDateEpoch, rr.terminationDateEpoch
+			SET m.terminationDate = 'sometime'`,
+		Parameters: map[string]interface{}{"uuid": membershipUUID},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{breakEpochs}))
+
+	report, err := NewCypherStore(db).RecomputeEpochs(context.Background(), 1, nil)
+	assert.NoError(err)
+	assert.Equal(1, report.MembershipsScanned)
+	assert.Equal(1, report.MembershipsFixed)
+	assert.True(report.RolesFixed > 0)
+	assert.Equal([]UnparseableDate{{UUID: membershipUUID, Property: "terminationDate", Value: "sometime"}}, report.Unparseable)
+
+	integrity, err := NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.NotContains(violationUUIDs(integrity, "missing-role-epochs"), membershipUUID)
+
+	report, err = NewCypherStore(db).RecomputeEpochs(context.Background(), 1, nil)
+	assert.NoError(err)
+	assert.Equal(0, report.MembershipsFixed+report.RolesFixed, "Nothing left to fix")
+}
+
+func TestIntegrityReportsOverlappingRolePeriods(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	membershipDriver := getCypherDriver(db)
+	defer cleanDB(db, t, assert)
+
+	held := fullMembership
+	held.MembershipRoles = []role{{roleUUID, []period{
+		{"2006-01-01T00:00:00Z", "2008-01-01T00:00:00Z"},
+		{"2008-01-01T00:00:00Z", ""},
+	}}}
+	assert.NoError(membershipDriver.Write(held, "TRANS_ID"), "Failed to write membership")
+
+	report, err := NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.NotContains(violationUUIDs(report, "overlapping-role-periods"), membershipUUID, "Periods may meet")
+
+	overlap := &neoism.CypherQuery{
+		Statement: `MATCH (m:Membership {uuid:$uuid})-[rr:HAS_ROLE]->()
+			WHERE rr.inceptionDate = '2008-01-01T00:00:00Z'
+			SET rr.inceptionDateEpoch = rr.inceptionDateEpoch - 86400`,
+		Parameters: map[string]interface{}{"uuid": membershipUUID},
+	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{overlap}))
+
+	report, err = NewCypherStore(db).CheckIntegrity(context.Background(), 10000)
+	assert.NoError(err)
+	assert.Contains(violationUUIDs(report, "overlapping-role-periods"), membershipUUID)
+}
+
+func violationUUIDs(report IntegrityReport, check string) []string {
+	uuids := []string{}
+	for _, c := range report.Checks {
+		if c.Name != check {
+			continue
+		}
+		for _, v := range c.Violations {
+			uuids = append(uuids, v.UUID)
+		}
+	}
+	return uuids
+}
@@ -0,0 +1,155 @@
+package memberships
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JSONLDType is the media type a GET of a membership can ask for to be sent it as JSON-LD
+const JSONLDType = "application/ld+json"
+
+// thingsURI is the base of the URIs the public API identifies things by
+const thingsURI = "http://api.ft.com/things/"
+
+// linkedMembership is a membership as JSON-LD, identifying it and the things it links to by their URIs and
+// labelling them where the graph has a prefLabel for them, as the public API does
+type linkedMembership struct {
+	Context           jsonLDContext `json:"@context" jsonschema:"required"`
+	ID                string        `json:"@id" jsonschema:"required"`
+	Type              string        `json:"@type" jsonschema:"required"`
+	PrefLabel         string        `json:"prefLabel,omitempty"`
+	InceptionDate     string        `json:"inceptionDate,omitempty" jsonschema:"date"`
+	TerminationDate   string        `json:"terminationDate,omitempty" jsonschema:"date"`
+	FactsetIdentifier string        `json:"factsetIdentifier,omitempty"`
+	Person            linkedThing   `json:"person" jsonschema:"required"`
+	Organisation      linkedThing   `json:"organisation" jsonschema:"required"`
+	Roles             []linkedRole  `json:"roles" jsonschema:"required"`
+}
+
+// jsonLDContext maps the properties of a linkedMembership to the FT ontology, and prefLabel to SKOS
+type jsonLDContext struct {
+	Vocab     string `json:"@vocab" jsonschema:"required"`
+	PrefLabel string `json:"prefLabel" jsonschema:"required"`
+}
+
+var membershipContext = jsonLDContext{
+	Vocab:     "http://www.ft.com/ontology/",
+	PrefLabel: "http://www.w3.org/2004/02/skos/core#prefLabel",
+}
+
+type linkedThing struct {
+	ID        string `json:"@id" jsonschema:"required"`
+	Type      string `json:"@type" jsonschema:"required"`
+	PrefLabel string `json:"prefLabel,omitempty"`
+}
+
+type linkedRole struct {
+	ID        string   `json:"@id" jsonschema:"required"`
+	Type      string   `json:"@type" jsonschema:"required"`
+	PrefLabel string   `json:"prefLabel,omitempty"`
+	Periods   []period `json:"periods"`
+}
+
+// ReadLinked reads the membership as JSON-LD, with the prefLabels of its person, organisation and roles
+func (s service) ReadLinked(ctx context.Context, uuid string) (linkedMembership, bool, error) {
+	m, found, err := s.store.Read(ctx, uuid)
+	if err != nil || !found {
+		return linkedMembership{}, found, err
+	}
+
+	uuids := []string{m.PersonUUID, m.OrganisationUUID}
+	for _, r := range m.MembershipRoles {
+		uuids = append(uuids, r.RoleUUID)
+	}
+	labels, err := s.store.ReadLabels(ctx, uuids)
+	if err != nil {
+		return linkedMembership{}, true, err
+	}
+	return linked(m, labels), true, nil
+}
+
+// linked converts the membership to JSON-LD, labelling the things it links to with the labels found for them
+func linked(m membership, labels map[string]string) linkedMembership {
+	lm := linkedMembership{
+		Context:           membershipContext,
+		ID:                thingsURI + m.UUID,
+		Type:              "Membership",
+		PrefLabel:         m.PrefLabel,
+		InceptionDate:     m.InceptionDate,
+		TerminationDate:   m.TerminationDate,
+		FactsetIdentifier: m.AlternativeIdentifiers.FactsetIdentifier,
+		Person:            linkedThing{thingsURI + m.PersonUUID, "Person", labels[m.PersonUUID]},
+		Organisation:      linkedThing{thingsURI + m.OrganisationUUID, "Organisation", labels[m.OrganisationUUID]},
+		Roles:             []linkedRole{},
+	}
+	for _, r := range m.MembershipRoles {
+		lm.Roles = append(lm.Roles, linkedRole{thingsURI + r.RoleUUID, "MembershipRole", labels[r.RoleUUID], r.Periods})
+	}
+	return lm
+}
+
+func (s service) readLinkedHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	lm, found, err := s.ReadLinked(ctx, uuid)
+	if err != nil {
+		logEntry(ctx, "read_linked", uuid, start).WithError(err).Error("Could not read membership")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Membership not found.")
+		return
+	}
+
+	w.Header().Set("Content-Type", JSONLDType+"; charset=UTF-8")
+	json.NewEncoder(w).Encode(lm)
+}
+
+// negotiate returns the first of the offered media types the Accept header gives the highest quality to. A type
+// takes its quality from the most specific range that matches it. If none is acceptable the first is returned, as
+// clients written before there was a choice expect it whatever they send.
+func negotiate(accept string, offers ...string) string {
+	best, bestQuality := offers[0], 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, accepted := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(accepted)
+			if err != nil {
+				continue
+			}
+			s := matchSpecificity(mediaType, offer)
+			if s <= specificity {
+				continue
+			}
+			specificity, quality = s, 1
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+				quality = q
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best
+}
+
+// matchSpecificity returns how specific the media range is if it matches the media type: 2 for the type itself,
+// 1 for its type/*, 0 for */*, or -1 if it does not match
+func matchSpecificity(mediaRange string, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
@@ -0,0 +1,58 @@
+package memberships
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, expected := range map[string]string{
+		"":                                      "application/json",
+		"*/*":                                   "application/json",
+		"text/html":                             "application/json",
+		"application/json":                      "application/json",
+		"application/ld+json":                   JSONLDType,
+		"application/ld+json, application/json": "application/json",
+		"application/json;q=0.5, application/ld+json":    JSONLDType,
+		"application/*;q=0.8, application/ld+json;q=0.9": JSONLDType,
+		"*/*;q=0.1, application/ld+json":                 JSONLDType,
+		"application/ld+json;q=0, */*":                   "application/json",
+		"application/json;q=0, */*":                      JSONLDType,
+	} {
+		assert.Equal(t, expected, negotiate(accept, "application/json", JSONLDType), "Accept: %s", accept)
+	}
+}
+
+func TestReadHandlerServesJSONLDWhenAskedForIt(t *testing.T) {
+	assert := assert.New(t)
+	mux := newTestMux(NewMembershipService(NewMemoryStore()))
+	body, _ := json.Marshal(fullMembership)
+	assert.Equal(http.StatusOK, serve(mux, "PUT", "/memberships/"+membershipUUID, string(body)).Code)
+
+	w := serve(mux, "GET", "/memberships/"+membershipUUID, "")
+	assert.Equal("application/json; charset=UTF-8", w.Header().Get("Content-Type"), "JSON is still the default")
+	assert.Equal("Accept", w.Header().Get("Vary"))
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &membership{}))
+
+	req := httptest.NewRequest("GET", "/memberships/"+membershipUUID, nil)
+	req.Header.Set("Accept", JSONLDType)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(JSONLDType+"; charset=UTF-8", w.Header().Get("Content-Type"))
+	assert.Equal("Accept", w.Header().Get("Vary"))
+	doc := map[string]interface{}{}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(thingsURI+membershipUUID, doc["@id"])
+	assert.Equal(thingsURI+personUUID, doc["person"].(map[string]interface{})["@id"])
+
+	req = httptest.NewRequest("GET", "/memberships/"+newOrgUUID, nil)
+	req.Header.Set("Accept", JSONLDType)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
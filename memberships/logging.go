@@ -0,0 +1,25 @@
+package memberships
+
+import (
+	"context"
+	"time"
+
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// logEntry starts a log line with the fields every line from this package has: the operation, the time it has
+// taken since start, the membership uuid if there is one, and the transaction id in ctx if there is one
+func logEntry(ctx context.Context, operation string, uuid string, start time.Time) *log.Entry {
+	fields := log.Fields{
+		"operation": operation,
+		"duration":  time.Since(start),
+	}
+	if uuid != "" {
+		fields["uuid"] = uuid
+	}
+	if tid, err := transactionidutils.GetTransactionIDFromContext(ctx); err == nil {
+		fields["transaction_id"] = tid
+	}
+	return log.WithFields(fields)
+}
@@ -0,0 +1,32 @@
+package memberships
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogLinesHaveTheRequestFields(t *testing.T) {
+	assert := assert.New(t)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	log.SetLevel(log.DebugLevel)
+	defer log.SetLevel(log.InfoLevel)
+
+	mux := newTestMux(NewMembershipService(NewCypherStore(fakeConnection{})))
+	req := httptest.NewRequest("PUT", "/memberships/"+newOrgUUID, strings.NewReader(`{"uuid":"`+newOrgUUID+`","personUuid":"`+personUUID+`","organisationUuid":"`+orgUUID+`"}`))
+	req.Header.Set("X-Request-Id", "tid_logged")
+	mux.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := hook.LastEntry()
+	if assert.NotNil(entry) {
+		assert.Equal("write", entry.Data["operation"])
+		assert.Equal(newOrgUUID, entry.Data["uuid"])
+		assert.Equal("tid_logged", entry.Data["transaction_id"])
+		assert.Contains(entry.Data, "duration")
+	}
+}
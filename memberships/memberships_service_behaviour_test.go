@@ -0,0 +1,452 @@
+package memberships
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	membershipUUID string = "79e4af29-9911-4cd0-860c-884dc2c33af6"
+	personUUID     string = "2bf87e91-a4de-4759-b646-291d21d9d485"
+	orgUUID        string = "4e6e4584-9a60-4320-a84b-d6fd234737cf"
+	roleUUID       string = "22416992-aa7e-47dc-9dd2-bdf877e4b877"
+	newPersonUUID  string = "11111111-1111-1111-1111-111111111111"
+	newOrgUUID     string = "22222222-2222-2222-2222-222222222222"
+)
+
+var fullMembership = membership{
+	UUID:                   membershipUUID,
+	OrganisationUUID:       orgUUID,
+	PersonUUID:             personUUID,
+	PrefLabel:              "Test label",
+	InceptionDate:          "2005-01-01T00:00:00.000Z",
+	TerminationDate:        "2007-01-01T00:00:00.000Z",
+	AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
+	MembershipRoles:        []role{role{roleUUID, []period{{"2006-01-01T00:00:00.000Z", "2006-09-01T00:00:00.000Z"}}}},
+}
+
+// The behaviour every MembershipStore must share. Each store's tests run these against a service using it.
+
+func assertCreatesFullMembership(t *testing.T, membershipDriver service) {
+	assert.NoError(t, membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	readMembershipAndCompare(fullMembership, t, membershipDriver)
+}
+
+func assertDeletesMembership(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	found, err := membershipDriver.Delete(membershipUUID, "TRANS_ID")
+	assert.True(found, "Didn't manage to delete membership for uuid %", membershipUUID)
+	assert.NoError(err, "Error deleting membership for uuid %s", membershipUUID)
+
+	m, found, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+
+	assert.Equal(membership{}, m, "Found membership %s who should have been deleted", m)
+	assert.False(found, "Found membership for uuid %s who should have been deleted", membershipUUID)
+	assert.NoError(err, "Error trying to find membership for uuid %s", membershipUUID)
+}
+
+func assertHandlesSpecialCharacters(t *testing.T, membershipDriver service) {
+	membershipToWrite := membership{UUID: membershipUUID, PrefLabel: "Engineér", PersonUUID: personUUID, OrganisationUUID: orgUUID, AlternativeIdentifiers: alternativeIdentifiers{FactsetIdentifier: "FACTSET_ID", UUIDS: []string{membershipUUID}}, MembershipRoles: []role{role{roleUUID, []period{{"2006-01-01T00:00:00.000Z", "2006-09-01T00:00:00.000Z"}}}}}
+
+	assert.NoError(t, membershipDriver.Write(membershipToWrite, "TRANS_ID"), "Failed to write membership")
+
+	readMembershipAndCompare(membershipToWrite, t, membershipDriver)
+}
+
+func assertUpdateRemovesPropertiesNoLongerPresent(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	storedFullMembership, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+
+	assert.NoError(err)
+	assert.NotEmpty(storedFullMembership)
+
+	var minimalMembership = membership{
+		UUID:                   membershipUUID,
+		OrganisationUUID:       orgUUID,
+		PersonUUID:             personUUID,
+		AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
+		MembershipRoles:        []role{role{roleUUID, []period{{"2008-01-01T00:00:00.000Z", "2009-06-01T00:00:00.000Z"}}}},
+	}
+
+	assert.NoError(membershipDriver.Write(minimalMembership, "TRANS_ID"), "Failed to write updated membership")
+
+	readMembershipAndCompare(minimalMembership, t, membershipDriver)
+}
+
+func assertUpdateReplacesOrgAndPerson(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	storedFullMembership, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+
+	assert.NoError(err)
+	assert.NotEmpty(storedFullMembership)
+
+	var updatedMembership = membership{
+		UUID:                   membershipUUID,
+		OrganisationUUID:       newOrgUUID,
+		PersonUUID:             newPersonUUID,
+		AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
+		MembershipRoles:        []role{role{roleUUID, []period{{"2008-01-01T00:00:00.000Z", "2009-06-01T00:00:00.000Z"}}}},
+	}
+
+	assert.NoError(membershipDriver.Write(updatedMembership, "TRANS_ID"), "Failed to write updated membership")
+
+	readMembershipAndCompare(updatedMembership, t, membershipDriver)
+}
+
+func assertBatchReadsMemberships(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	expected, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+
+	result, err := membershipDriver.BatchRead(context.Background(), []string{newPersonUUID, membershipUUID, newPersonUUID})
+	assert.NoError(err)
+	assert.Equal(map[string]membership{membershipUUID: expected.(membership)}, result.Memberships)
+	assert.Equal([]string{newPersonUUID}, result.Missing, "Missing uuids are listed once")
+}
+
+func assertWriteManyMatchesWrite(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	var updatedMembership = membership{
+		UUID:                   membershipUUID,
+		OrganisationUUID:       newOrgUUID,
+		PersonUUID:             newPersonUUID,
+		AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
+		MembershipRoles:        []role{role{roleUUID, []period{{"2006-01-01T00:00:00.000Z", ""}}}},
+	}
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	assert.NoError(membershipDriver.Write(updatedMembership, "TRANS_ID"), "Failed to write updated membership")
+	written, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+
+	_, err = membershipDriver.Delete(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+
+	assert.NoError(membershipDriver.WriteMany([]interface{}{fullMembership, updatedMembership}, "TRANS_ID"), "Failed to write memberships")
+	readMembershipAndCompare(written.(membership), t, membershipDriver)
+}
+
+func assertPatchesMembership(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	_, found, err := membershipDriver.Patch(ctx, membershipUUID, MergePatchType, []byte(`{"prefLabel":"Patched"}`))
+	assert.NoError(err)
+	assert.False(found, "Nothing to patch")
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	expected := fullMembership
+	expected.PrefLabel = "Patched"
+	expected.TerminationDate = ""
+	patched, found, err := membershipDriver.Patch(ctx, membershipUUID, MergePatchType, []byte(`{"prefLabel":"Patched","terminationDate":null}`))
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(expected, patched)
+	readMembershipAndCompare(expected, t, membershipDriver)
+
+	expected.MembershipRoles = []role{role{roleUUID, []period{{"2006-01-01T00:00:00.000Z", "2006-12-01T00:00:00.000Z"}}}}
+	_, _, err = membershipDriver.Patch(ctx, membershipUUID, JSONPatchType, []byte(`[
+		{"op":"test","path":"/prefLabel","value":"Patched"},
+		{"op":"replace","path":"/membershipRoles/0/periods/0/terminationDate","value":"2006-12-01T00:00:00.000Z"}
+	]`))
+	assert.NoError(err)
+	readMembershipAndCompare(expected, t, membershipDriver)
+
+	_, _, err = membershipDriver.Patch(ctx, membershipUUID, MergePatchType, []byte(`{"personUuid":null}`))
+	assert.IsType(invalidMembershipError{}, err)
+	_, _, err = membershipDriver.Patch(ctx, membershipUUID, JSONPatchType, []byte(`[{"op":"test","path":"/prefLabel","value":"Other"}]`))
+	assert.IsType(badPatchError{}, err)
+	readMembershipAndCompare(expected, t, membershipDriver)
+}
+
+func assertWritesAndDeletesRoles(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+	store := membershipDriver.store
+	ended := role{roleUUID, []period{{"2006-01-01T00:00:00.000Z", "2008-01-01T00:00:00.000Z"}}}
+	added := role{newOrgUUID, []period{{"2008-01-01T00:00:00.000Z", ""}}}
+
+	found, err := store.WriteRole(ctx, membershipUUID, added)
+	assert.NoError(err)
+	assert.False(found, "No membership to add the role to")
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	read, found, err := store.ReadRole(ctx, membershipUUID, roleUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(fullMembership.MembershipRoles[0], read)
+
+	found, err = store.WriteRole(ctx, membershipUUID, ended)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = store.WriteRole(ctx, membershipUUID, added)
+	assert.NoError(err)
+	assert.True(found)
+
+	m, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+	assert.Equal([]role{added, ended}, m.(membership).MembershipRoles, "The role is replaced and the new one added, in uuid order")
+	assert.Equal(fullMembership.PrefLabel, m.(membership).PrefLabel, "The rest of the membership is left as it was")
+
+	found, err = store.DeleteRole(ctx, membershipUUID, roleUUID)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = store.DeleteRole(ctx, membershipUUID, roleUUID)
+	assert.NoError(err)
+	assert.False(found, "Already deleted")
+	_, found, err = store.ReadRole(ctx, membershipUUID, roleUUID)
+	assert.NoError(err)
+	assert.False(found)
+
+	m, _, err = membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assert.NoError(err)
+	assert.Equal([]role{added}, m.(membership).MembershipRoles)
+}
+
+func assertWritesRolePeriods(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	// The same role twice, as the transformer sends a role held more than once
+	thing, _, err := membershipDriver.DecodeJSON(json.NewDecoder(strings.NewReader(`{
+		"uuid": "` + membershipUUID + `", "personUuid": "` + personUUID + `", "organisationUuid": "` + orgUUID + `",
+		"alternativeIdentifiers": {"uuids": ["` + membershipUUID + `"]},
+		"membershipRoles": [
+			{"roleuuid": "` + roleUUID + `", "inceptionDate": "2012-01-01T00:00:00Z"},
+			{"roleuuid": "` + roleUUID + `", "inceptionDate": "2006-01-01T00:00:00Z", "terminationDate": "2008-01-01T00:00:00Z"}
+		]}`)))
+	assert.NoError(err)
+	assert.NoError(membershipDriver.Write(thing, "TRANS_ID"), "Failed to write membership")
+
+	expected := thing.(membership)
+	expected.MembershipRoles = []role{{roleUUID, []period{
+		{"2006-01-01T00:00:00Z", "2008-01-01T00:00:00Z"},
+		{"2012-01-01T00:00:00Z", ""},
+	}}}
+	readMembershipAndCompare(expected, t, membershipDriver)
+
+	_, _, err = membershipDriver.DecodeJSON(json.NewDecoder(strings.NewReader(`{"uuid": "` + membershipUUID + `",
+		"personUuid": "` + personUUID + `", "organisationUuid": "` + orgUUID + `",
+		"membershipRoles": [{"roleuuid": "` + roleUUID + `", "periods": [
+			{"inceptionDate": "2006-01-01T00:00:00Z"},
+			{"inceptionDate": "2012-01-01T00:00:00Z"}
+		]}]}`)))
+	assert.EqualError(err, "membershipRoles[0] has overlapping periods")
+}
+
+func assertRejectsDatesThatDoNotParse(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+	store := membershipDriver.store
+
+	invalid := fullMembership
+	invalid.InceptionDate = "yesterday"
+	assert.EqualError(store.Write(ctx, invalid), "inceptionDate "+invalidDateError("yesterday"))
+	assert.Error(store.WriteMany(ctx, []membership{fullMembership, invalid}))
+	_, err := store.Create(ctx, invalid)
+	assert.Error(err)
+	_, found, _ := store.Read(ctx, membershipUUID)
+	assert.False(found, "Nothing is written")
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"))
+	_, err = store.WriteRole(ctx, membershipUUID, role{roleUUID, []period{{"2006", "tomorrow"}}})
+	assert.EqualError(err, "terminationDate "+invalidDateError("tomorrow"))
+	_, _, err = store.Update(ctx, membershipUUID, func(m membership) (membership, error) {
+		m.TerminationDate = "tomorrow"
+		return m, nil
+	})
+	assert.Error(err)
+	readMembershipAndCompare(fullMembership, t, membershipDriver)
+}
+
+// invalidDateError is the error parseDate gives for the date
+func invalidDateError(date string) string {
+	_, _, err := parseDate(date)
+	return err.Error()
+}
+
+func assertKeepsDatePrecision(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	partial := fullMembership
+	partial.InceptionDate = "2005"
+	partial.TerminationDate = "2007-06"
+	partial.MembershipRoles = []role{{roleUUID, []period{{"2005-03-14", "2006-09-01T00:00:00.000Z"}}}}
+	assert.NoError(membershipDriver.Write(partial, "TRANS_ID"), "Failed to write membership")
+
+	readMembershipAndCompare(partial, t, membershipDriver)
+	r, found, err := membershipDriver.store.ReadRole(ctx, membershipUUID, roleUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(partial.MembershipRoles[0], r)
+}
+
+func assertKeepsSource(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+
+	thing, _, err := membershipDriver.DecodeJSON(json.NewDecoder(strings.NewReader(`{
+		"uuid": "` + membershipUUID + `", "personUuid": "` + personUUID + `", "organisationUuid": "` + orgUUID + `",
+		"alternativeIdentifiers": {"uuids": ["` + membershipUUID + `"]},
+		"source": {"authority": "FACTSET", "system": "memberships-transformer", "recordId": "FS-1"}}`)))
+	assert.NoError(err)
+	sourced := thing.(membership)
+	assert.NotEmpty(sourced.Source.IngestedAt, "The ingestion time is set when the membership is decoded")
+	assert.NoError(membershipDriver.Write(sourced, "TRANS_ID"), "Failed to write membership")
+	readMembershipAndCompare(sourced, t, membershipDriver)
+
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	readMembershipAndCompare(fullMembership, t, membershipDriver)
+}
+
+func assertReadsLinkedMembership(t *testing.T, membershipDriver service) {
+	assert := assert.New(t)
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+
+	labels, err := membershipDriver.store.ReadLabels(ctx, []string{membershipUUID, personUUID, newOrgUUID})
+	assert.NoError(err)
+	assert.Equal(map[string]string{membershipUUID: "Test label"}, labels, "Only things with a prefLabel are listed")
+
+	lm, found, err := membershipDriver.ReadLinked(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(linkedMembership{
+		Context:           membershipContext,
+		ID:                thingsURI + membershipUUID,
+		Type:              "Membership",
+		PrefLabel:         "Test label",
+		InceptionDate:     fullMembership.InceptionDate,
+		TerminationDate:   fullMembership.TerminationDate,
+		FactsetIdentifier: "FACTSET_ID",
+		Person:            linkedThing{thingsURI + personUUID, "Person", ""},
+		Organisation:      linkedThing{thingsURI + orgUUID, "Organisation", ""},
+		Roles:             []linkedRole{{thingsURI + roleUUID, "MembershipRole", "", fullMembership.MembershipRoles[0].Periods}},
+	}, lm)
+
+	_, found, err = membershipDriver.ReadLinked(ctx, newOrgUUID)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+func readMembershipAndCompare(expected membership, t *testing.T, membershipDriver service) {
+	sort.Strings(expected.AlternativeIdentifiers.UUIDS)
+
+	actual, found, err := membershipDriver.Read(expected.UUID, "TRANS_ID")
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	actualMembership := actual.(membership)
+	sort.Strings(actualMembership.AlternativeIdentifiers.UUIDS)
+
+	assert.EqualValues(t, expected, actualMembership)
+}
+
+func exportedMemberships(t *testing.T, r io.Reader) []membership {
+	dec := json.NewDecoder(r)
+	exported := []membership{}
+	for {
+		m := membership{}
+		if err := dec.Decode(&m); err == io.EOF {
+			return exported
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		exported = append(exported, m)
+	}
+}
+
+var storeBehaviours = map[string]func(*testing.T, service){
+	"CreateFullMembership":                      assertCreatesFullMembership,
+	"DeleteMembership":                          assertDeletesMembership,
+	"CreateHandlesSpecialCharacters":            assertHandlesSpecialCharacters,
+	"UpdateWillRemovePropertiesNoLongerPresent": assertUpdateRemovesPropertiesNoLongerPresent,
+	"UpdateWillReplaceOrgAndPerson":             assertUpdateReplacesOrgAndPerson,
+	"BatchReadMemberships":                      assertBatchReadsMemberships,
+	"WriteManyMatchesWrite":                     assertWriteManyMatchesWrite,
+	"PatchMembership":                           assertPatchesMembership,
+	"WriteAndDeleteRoles":                       assertWritesAndDeletesRoles,
+	"WriteRolePeriods":                          assertWritesRolePeriods,
+	"RejectDatesThatDoNotParse":                 assertRejectsDatesThatDoNotParse,
+	"KeepDatePrecision":                         assertKeepsDatePrecision,
+	"KeepSource":                                assertKeepsSource,
+	"ReadLinkedMembership":                      assertReadsLinkedMembership,
+}
+
+func TestMemoryStore(t *testing.T) {
+	for name, behaviour := range storeBehaviours {
+		t.Run(name, func(t *testing.T) {
+			behaviour(t, NewMembershipService(NewMemoryStore()))
+		})
+	}
+}
+
+func TestBatchReadHandler(t *testing.T) {
+	assert := assert.New(t)
+	membershipDriver := NewMembershipService(NewMemoryStore())
+	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"))
+
+	w := httptest.NewRecorder()
+	membershipDriver.BatchReadHandler(w, httptest.NewRequest("POST", "/memberships/__batch-read", strings.NewReader(`{"uuids":["`+membershipUUID+`","`+newOrgUUID+`"]}`)))
+	assert.Equal(http.StatusOK, w.Code)
+
+	result := BatchReadResult{}
+	assert.NoError(json.NewDecoder(w.Body).Decode(&result))
+	assert.Contains(result.Memberships, membershipUUID)
+	assert.Equal([]string{newOrgUUID}, result.Missing)
+
+	w = httptest.NewRecorder()
+	membershipDriver.BatchReadHandler(w, httptest.NewRequest("POST", "/memberships/__batch-read", strings.NewReader(`["a"]`)))
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	membershipDriver.BatchReadHandler(w, httptest.NewRequest("POST", "/memberships/__batch-read", strings.NewReader(`{"uuids":[`+strings.Repeat(`"a",`, MaxBatchReadSize)+`"a"]}`)))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
+
+func TestMemoryStoreCountAndExport(t *testing.T) {
+	assert := assert.New(t)
+	membershipDriver := NewMembershipService(NewMemoryStore())
+
+	for _, uuid := range []string{"c", "a", "b"} {
+		m := fullMembership
+		m.UUID = uuid
+		assert.NoError(membershipDriver.Write(m, "TRANS_ID"))
+	}
+
+	count, err := membershipDriver.Count()
+	assert.NoError(err)
+	assert.Equal(3, count)
+
+	buf := &bytes.Buffer{}
+	exported, err := membershipDriver.Export(context.Background(), buf, time.Time{}, 2)
+	assert.NoError(err)
+	assert.Equal(3, exported)
+
+	uuids := []string{}
+	for _, m := range exportedMemberships(t, buf) {
+		uuids = append(uuids, m.UUID)
+	}
+	assert.Equal([]string{"a", "b", "c"}, uuids)
+
+	buf.Reset()
+	exported, err = membershipDriver.Export(context.Background(), buf, time.Now().Add(time.Hour), 2)
+	assert.NoError(err)
+	assert.Equal(0, exported)
+}
@@ -1,3 +1,4 @@
+//go:build !jenkins
 // +build !jenkins
 
 package memberships
@@ -5,128 +6,162 @@ package memberships
 import (
 	"fmt"
 	"os"
-	"sort"
 	"testing"
 
 	"github.com/Financial-Times/base-ft-rw-app-go/baseftrwapp"
+	"github.com/Financial-Times/memberships-rw-neo4j/bolt"
 	"github.com/Financial-Times/neo-utils-go/neoutils"
 	"github.com/jmcvetta/neoism"
 	"github.com/stretchr/testify/assert"
 )
 
-const (
-	membershipUUID string = "79e4af29-9911-4cd0-860c-884dc2c33af6"
-	personUUID     string = "2bf87e91-a4de-4759-b646-291d21d9d485"
-	orgUUID        string = "4e6e4584-9a60-4320-a84b-d6fd234737cf"
-	roleUUID       string = "22416992-aa7e-47dc-9dd2-bdf877e4b877"
-	newPersonUUID  string = "11111111-1111-1111-1111-111111111111"
-	newOrgUUID     string = "22222222-2222-2222-2222-222222222222"
-)
+var membershipsService baseftrwapp.Service
+
+func TestCreateFullMembership(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-var fullMembership = membership{
-	UUID:                   membershipUUID,
-	OrganisationUUID:       orgUUID,
-	PersonUUID:             personUUID,
-	PrefLabel:              "Test label",
-	InceptionDate:          "2005-01-01T00:00:00.000Z",
-	TerminationDate:        "2007-01-01T00:00:00.000Z",
-	AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
-	MembershipRoles:        []role{role{roleUUID, "2006-01-01T00:00:00.000Z", "2006-09-01T00:00:00.000Z"}},
+	assertCreatesFullMembership(t, getCypherDriver(db))
 }
 
-var membershipsService baseftrwapp.Service
+func TestDeleteMembership(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-func TestCreateFullMembership(t *testing.T) {
+	assertDeletesMembership(t, getCypherDriver(db))
+}
+
+func TestCreateHandlesSpecialCharacters(t *testing.T) {
 	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
+
+	assertHandlesSpecialCharacters(t, getCypherDriver(db))
+}
 
+func TestUpdateWillRemovePropertiesNoLongerPresent(t *testing.T) {
+	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
-	membershipDriver := getCypherDriver(db)
 	defer cleanDB(db, t, assert)
 
-	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
-	readMembershipAndCompare(fullMembership, t, db)
+	assertUpdateRemovesPropertiesNoLongerPresent(t, getCypherDriver(db))
 }
 
-func TestDeleteMembership(t *testing.T) {
+func TestUpdateWillReplaceOrgAndPerson(t *testing.T) {
 	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
-	membershipDriver := getCypherDriver(db)
 	defer cleanDB(db, t, assert)
 
-	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
+	assertUpdateReplacesOrgAndPerson(t, getCypherDriver(db))
+}
 
-	found, err := membershipDriver.Delete(membershipUUID, "TRANS_ID")
-	assert.True(found, "Didn't manage to delete membership for uuid %", membershipUUID)
-	assert.NoError(err, "Error deleting membership for uuid %s", membershipUUID)
+func TestBatchReadMemberships(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-	m, found, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assertBatchReadsMemberships(t, getCypherDriver(db))
+}
 
-	assert.Equal(membership{}, m, "Found membership %s who should have been deleted", m)
-	assert.False(found, "Found membership for uuid %s who should have been deleted", membershipUUID)
-	assert.NoError(err, "Error trying to find membership for uuid %s", membershipUUID)
+func TestWriteManyMatchesWrite(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
+
+	assertWriteManyMatchesWrite(t, getCypherDriver(db))
 }
 
-func TestCreateHandlesSpecialCharacters(t *testing.T) {
+func TestPatchMembership(t *testing.T) {
 	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
-	membershipDriver := getCypherDriver(db)
 	defer cleanDB(db, t, assert)
 
-	membershipToWrite := membership{UUID: membershipUUID, PrefLabel: "Engineér", PersonUUID: personUUID, OrganisationUUID: orgUUID, AlternativeIdentifiers: alternativeIdentifiers{FactsetIdentifier: "FACTSET_ID", UUIDS: []string{membershipUUID}}, MembershipRoles: []role{role{roleUUID, "2006-01-01T00:00:00.000Z", "2006-09-01T00:00:00.000Z"}}}
+	assertPatchesMembership(t, getCypherDriver(db))
+}
 
-	assert.NoError(membershipDriver.Write(membershipToWrite, "TRANS_ID"), "Failed to write membership")
+func TestWriteAndDeleteRoles(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-	readMembershipAndCompare(membershipToWrite, t, db)
+	assertWritesAndDeletesRoles(t, getCypherDriver(db))
 }
 
-func TestUpdateWillRemovePropertiesNoLongerPresent(t *testing.T) {
+func TestWriteRolePeriods(t *testing.T) {
 	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
-	membershipDriver := getCypherDriver(db)
 	defer cleanDB(db, t, assert)
 
-	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
-	storedFullMembership, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assertWritesRolePeriods(t, getCypherDriver(db))
+}
 
-	assert.NoError(err)
-	assert.NotEmpty(storedFullMembership)
-
-	var minimalMembership = membership{
-		UUID:                   membershipUUID,
-		OrganisationUUID:       orgUUID,
-		PersonUUID:             personUUID,
-		AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
-		MembershipRoles:        []role{role{roleUUID, "value1", "value2"}},
-	}
+func TestKeepDatePrecision(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-	assert.NoError(membershipDriver.Write(minimalMembership, "TRANS_ID"), "Failed to write updated membership")
+	assertKeepsDatePrecision(t, getCypherDriver(db))
+}
+
+func TestKeepSource(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
 
-	readMembershipAndCompare(minimalMembership, t, db)
+	assertKeepsSource(t, getCypherDriver(db))
 }
 
-func TestUpdateWillReplaceOrgAndPerson(t *testing.T) {
+func TestReadLinkedMembership(t *testing.T) {
 	assert := assert.New(t)
 	db := getDatabaseConnectionAndCheckClean(t, assert)
-	membershipDriver := getCypherDriver(db)
 	defer cleanDB(db, t, assert)
+	membershipDriver := getCypherDriver(db)
 
-	assert.NoError(membershipDriver.Write(fullMembership, "TRANS_ID"), "Failed to write membership")
-	storedFullMembership, _, err := membershipDriver.Read(membershipUUID, "TRANS_ID")
+	assertReadsLinkedMembership(t, membershipDriver)
 
-	assert.NoError(err)
-	assert.NotEmpty(storedFullMembership)
-
-	var updatedMembership = membership{
-		UUID:                   membershipUUID,
-		OrganisationUUID:       newOrgUUID,
-		PersonUUID:             newPersonUUID,
-		AlternativeIdentifiers: alternativeIdentifiers{"FACTSET_ID", []string{membershipUUID}},
-		MembershipRoles:        []role{role{roleUUID, "value1", "value2"}},
+	label := &neoism.CypherQuery{
+		Statement:  `MATCH (t:Thing) WHERE t.uuid IN [$person, $role] SET t.prefLabel = t.uuid + ' label'`,
+		Parameters: map[string]interface{}{"person": personUUID, "role": roleUUID},
 	}
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{label}))
+	lm, _, err := membershipDriver.ReadLinked(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.Equal(personUUID+" label", lm.Person.PrefLabel, "The person's prefLabel is read from the graph")
+	assert.Equal(roleUUID+" label", lm.Roles[0].PrefLabel, "The role's prefLabel is read from the graph")
+	assert.Empty(lm.Organisation.PrefLabel)
+}
 
-	assert.NoError(membershipDriver.Write(updatedMembership, "TRANS_ID"), "Failed to write updated membership")
-
-	readMembershipAndCompare(updatedMembership, t, db)
+func TestUpdateReappliesTheChangeAfterAConcurrentWrite(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnectionAndCheckClean(t, assert)
+	defer cleanDB(db, t, assert)
+	store := NewCypherStore(db)
+	assert.NoError(store.Initialise())
+	assert.NoError(store.Write(ctx, fullMembership))
+
+	attempts := 0
+	updated, found, err := store.Update(ctx, membershipUUID, func(m membership) (membership, error) {
+		attempts++
+		if attempts == 1 {
+			concurrent := fullMembership
+			concurrent.PrefLabel = "Concurrent"
+			assert.NoError(store.Write(ctx, concurrent))
+		}
+		m.TerminationDate = ""
+		return m, nil
+	})
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(2, attempts)
+	assert.Equal("Concurrent", updated.PrefLabel, "The change is applied to the membership as it was written concurrently")
+
+	_, _, err = store.Update(ctx, membershipUUID, func(m membership) (membership, error) {
+		assert.NoError(store.Write(ctx, fullMembership))
+		return m, nil
+	})
+	assert.Equal(ErrConflict, err)
 }
 
 func TestWriteCalculateEpocCorrectly(t *testing.T) {
@@ -153,7 +188,7 @@ func TestWriteCalculateEpocCorrectly(t *testing.T) {
 		Result: &result,
 	}
 
-	err := membershipDriver.conn.CypherBatch([]*neoism.CypherQuery{getEpocQuery})
+	err := db.CypherBatch([]*neoism.CypherQuery{getEpocQuery})
 	assert.NoError(err)
 	assert.Equal(1104537600, result[0].MembershipInceptionDateEpoch, "Epoc of 2005-01-01T01:00:00.000Z should be 1104537600")
 	assert.Equal(1167609600, result[0].MembershipTerminationDateEpoch, "Epoc of 2007-01-01T01:00:00.000Z should be 1167609600")
@@ -167,6 +202,12 @@ func getDatabaseConnection(assert *assert.Assertions) neoutils.NeoConnection {
 		url = "http://localhost:7474/db/data"
 	}
 
+	if bolt.IsBoltURL(url) {
+		db, err := bolt.Connect(url, bolt.Config{Database: os.Getenv("NEO4J_TEST_DATABASE")})
+		assert.NoError(err, "Failed to connect to Neo4j over Bolt")
+		return db
+	}
+
 	conf := neoutils.DefaultConnectionConfig()
 	conf.Transactional = false
 	db, err := neoutils.Connect(url, conf)
@@ -180,14 +221,14 @@ func getCypherDriver(db neoutils.NeoConnection) service {
 	return cr
 }
 
-func getDatabaseConnectionAndCheckClean(t *testing.T, assert *assert.Assertions) neoutils.NeoConnection {
+func getDatabaseConnectionAndCheckClean(t testing.TB, assert *assert.Assertions) neoutils.NeoConnection {
 	db := getDatabaseConnection(assert)
 	cleanDB(db, t, assert)
 	checkDbClean(db, t)
 	return db
 }
 
-func cleanDB(db neoutils.NeoConnection, t *testing.T, assert *assert.Assertions) {
+func cleanDB(db neoutils.NeoConnection, t testing.TB, assert *assert.Assertions) {
 	qs := []*neoism.CypherQuery{
 		{
 			Statement: fmt.Sprintf("MATCH (fp:Thing {uuid: '%v'})<-[:IDENTIFIES*0..]-(i:Identifier) DETACH DELETE fp, i", membershipUUID),
@@ -213,7 +254,7 @@ func cleanDB(db neoutils.NeoConnection, t *testing.T, assert *assert.Assertions)
 	assert.NoError(err)
 }
 
-func checkDbClean(db neoutils.NeoConnection, t *testing.T) {
+func checkDbClean(db neoutils.NeoConnection, t testing.TB) {
 	assert := assert.New(t)
 
 	result := []struct {
@@ -222,7 +263,7 @@ func checkDbClean(db neoutils.NeoConnection, t *testing.T) {
 
 	checkGraph := neoism.CypherQuery{
 		Statement: `
-			MATCH (m:Thing) WHERE m.uuid in {uuids} RETURN m.uuid
+			MATCH (m:Thing) WHERE m.uuid in $uuids RETURN m.uuid
 		`,
 		Parameters: neoism.Props{
 			"uuids": []string{roleUUID, personUUID, orgUUID, membershipUUID},
@@ -234,15 +275,17 @@ func checkDbClean(db neoutils.NeoConnection, t *testing.T) {
 	assert.Empty(result)
 }
 
-func readMembershipAndCompare(expected membership, t *testing.T, db neoutils.NeoConnection) {
-	sort.Strings(expected.AlternativeIdentifiers.UUIDS)
-
-	actual, found, err := getCypherDriver(db).Read(expected.UUID, "TRANS_ID")
-	assert.NoError(t, err)
-	assert.True(t, found)
+func TestMigrateRecordsSchemaVersion(t *testing.T) {
+	assert := assert.New(t)
+	db := getDatabaseConnection(assert)
+	store := NewCypherStore(db)
+	store.Initialise()
 
-	actualMembership := actual.(membership)
-	sort.Strings(actualMembership.AlternativeIdentifiers.UUIDS)
+	version, err := store.SchemaVersion()
+	assert.NoError(err)
+	assert.Equal(migrations[len(migrations)-1].Version, version)
 
-	assert.EqualValues(t, expected, actualMembership)
+	applied, err := store.Migrate()
+	assert.NoError(err)
+	assert.Equal(0, applied, "Migrations should only be applied once")
 }
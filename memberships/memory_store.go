@@ -0,0 +1,269 @@
+package memberships
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryStore struct {
+	sync.RWMutex
+	memberships  map[string]membership
+	lastModified map[string]time.Time
+}
+
+// NewMemoryStore returns a MembershipStore that keeps memberships in memory, returning them as the neo4j store would
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		memberships:  map[string]membership{},
+		lastModified: map[string]time.Time{},
+	}
+}
+
+func (s *memoryStore) Initialise() error {
+	return nil
+}
+
+func (s *memoryStore) Read(ctx context.Context, uuid string) (membership, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	m, found := s.memberships[uuid]
+	if !found {
+		return membership{}, false, nil
+	}
+	return asRead(m), true, nil
+}
+
+func (s *memoryStore) ReadMany(ctx context.Context, uuids []string) (map[string]membership, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	found := map[string]membership{}
+	for _, uuid := range uuids {
+		if m, ok := s.memberships[uuid]; ok {
+			found[uuid] = asRead(m)
+		}
+	}
+	return found, nil
+}
+
+// ReadLabels returns the prefLabels of the memberships, as the memory store holds no other things
+func (s *memoryStore) ReadLabels(ctx context.Context, uuids []string) (map[string]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	labels := map[string]string{}
+	for _, uuid := range uuids {
+		if m, ok := s.memberships[uuid]; ok && m.PrefLabel != "" {
+			labels[uuid] = m.PrefLabel
+		}
+	}
+	return labels, nil
+}
+
+func (s *memoryStore) Write(ctx context.Context, m membership) error {
+	if err := checkDates(m); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	s.memberships[m.UUID] = asRead(m)
+	s.lastModified[m.UUID] = time.Now()
+	return nil
+}
+
+func (s *memoryStore) WriteMany(ctx context.Context, ms []membership) error {
+	for _, m := range ms {
+		if err := checkDates(m); err != nil {
+			return err
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for _, m := range ms {
+		s.memberships[m.UUID] = asRead(m)
+		s.lastModified[m.UUID] = now
+	}
+	return nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	m, found := s.memberships[uuid]
+	if !found {
+		return membership{}, false, nil
+	}
+	updated, err := change(asRead(m))
+	if err != nil {
+		return membership{}, true, err
+	}
+	if err := checkDates(updated); err != nil {
+		return membership{}, true, err
+	}
+	s.memberships[uuid] = asRead(updated)
+	s.lastModified[uuid] = time.Now()
+	return asRead(updated), true, nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, m membership) (bool, error) {
+	if err := checkDates(m); err != nil {
+		return false, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	if _, found := s.memberships[m.UUID]; found {
+		return false, nil
+	}
+	s.memberships[m.UUID] = asRead(m)
+	s.lastModified[m.UUID] = time.Now()
+	return true, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, uuid string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	_, found := s.memberships[uuid]
+	delete(s.memberships, uuid)
+	delete(s.lastModified, uuid)
+	return found, nil
+}
+
+func (s *memoryStore) DeleteIf(ctx context.Context, uuid string, check func(membership) error) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	m, found := s.memberships[uuid]
+	if !found {
+		return false, nil
+	}
+	if err := check(m); err != nil {
+		return true, err
+	}
+	delete(s.memberships, uuid)
+	delete(s.lastModified, uuid)
+	return true, nil
+}
+
+func (s *memoryStore) ReadRole(ctx context.Context, uuid string, roleUUID string) (role, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, r := range s.memberships[uuid].MembershipRoles {
+		if r.RoleUUID == roleUUID {
+			return r, true, nil
+		}
+	}
+	return role{}, false, nil
+}
+
+func (s *memoryStore) WriteRole(ctx context.Context, uuid string, r role) (bool, error) {
+	if err := checkRoleDates(r); err != nil {
+		return false, err
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	m, found := s.memberships[uuid]
+	if !found {
+		return false, nil
+	}
+	roles := withoutRole(m.MembershipRoles, r.RoleUUID)
+	m.MembershipRoles = append(roles, r)
+	s.memberships[uuid] = asRead(m)
+	s.lastModified[uuid] = time.Now()
+	return true, nil
+}
+
+func (s *memoryStore) DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	m, found := s.memberships[uuid]
+	if !found {
+		return false, nil
+	}
+	roles := withoutRole(m.MembershipRoles, roleUUID)
+	if len(roles) == len(m.MembershipRoles) {
+		return false, nil
+	}
+	m.MembershipRoles = roles
+	s.memberships[uuid] = m
+	s.lastModified[uuid] = time.Now()
+	return true, nil
+}
+
+// withoutRole returns a copy of roles without those with the role uuid
+func withoutRole(roles []role, roleUUID string) []role {
+	kept := []role{}
+	for _, r := range roles {
+		if r.RoleUUID != roleUUID {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func (s *memoryStore) Count(ctx context.Context) (int, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return len(s.memberships), nil
+}
+
+func (s *memoryStore) Check() error {
+	return nil
+}
+
+func (s *memoryStore) ReadPage(ctx context.Context, after string, since time.Time, limit int) ([]membership, string, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	uuids := []string{}
+	for uuid := range s.memberships {
+		if uuid > after && (since.IsZero() || !s.lastModified[uuid].Before(since)) {
+			uuids = append(uuids, uuid)
+		}
+	}
+	sort.Strings(uuids)
+
+	next := ""
+	if len(uuids) > limit {
+		uuids = uuids[:limit]
+		next = uuids[limit-1]
+	}
+
+	page := make([]membership, 0, len(uuids))
+	for _, uuid := range uuids {
+		page = append(page, asRead(s.memberships[uuid]))
+	}
+	return page, next, nil
+}
+
+// asRead copies a membership into the shape the neo4j store reads it back in:
+// empty lists rather than nil, roles merged and ordered as normaliseRoles does, and alternative uuids without duplicates
+func asRead(m membership) membership {
+	m.MembershipRoles = normaliseRoles(m.MembershipRoles)
+	if m.Source != nil {
+		s := *m.Source
+		m.Source = &s
+	}
+
+	uuids := []string{}
+	seen := map[string]bool{}
+	for _, uuid := range m.AlternativeIdentifiers.UUIDS {
+		if !seen[uuid] {
+			seen[uuid] = true
+			uuids = append(uuids, uuid)
+		}
+	}
+	m.AlternativeIdentifiers.UUIDS = uuids
+	return m
+}
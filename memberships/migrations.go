@@ -0,0 +1,147 @@
+package memberships
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+const schemaVersionName = "memberships-rw-neo4j"
+
+// Migration moves the graph from the previous schema version to Version.
+// Each statement runs in its own transaction on the direct connection, as Neo4j does not allow schema and data
+// changes in the same one, which a batching connection could merge them into. Statements must therefore be safe to
+// run again if a migration is interrupted. A statement creating an index or
+// constraint is skipped if the graph already has it, as Neo4j 4 fails to create one twice, and graphs written
+// before there were migrations have the schema of the first ones without a schema version.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+}
+
+// migrations must be in ascending version order, and must never be changed once released: add a new one instead.
+// They use the CREATE INDEX ON and CREATE CONSTRAINT ON ... ASSERT syntax of Neo4j 3.x, which 4.x still accepts,
+// rather than the IF NOT EXISTS that Neo4j 3.x does not have, so that they run on both. Neo4j 5 accepts neither.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Index identifier values",
+		Statements: []string{
+			`CREATE INDEX ON :Identifier(value)`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "Make uuids and identifier values unique",
+		Statements: []string{
+			`CREATE CONSTRAINT ON (t:Thing) ASSERT t.uuid IS UNIQUE`,
+			`CREATE CONSTRAINT ON (c:Concept) ASSERT c.uuid IS UNIQUE`,
+			`CREATE CONSTRAINT ON (m:Membership) ASSERT m.uuid IS UNIQUE`,
+			`CREATE CONSTRAINT ON (i:FactsetIdentifier) ASSERT i.value IS UNIQUE`,
+			`CREATE CONSTRAINT ON (i:UPPIdentifier) ASSERT i.value IS UNIQUE`,
+		},
+	},
+}
+
+// SchemaVersion returns the version of the last migration applied to the graph, or 0 if none has been
+func (s cypherStore) SchemaVersion() (int, error) {
+	results := []struct {
+		Version int `json:"version"`
+	}{}
+
+	query := &neoism.CypherQuery{
+		Statement: `MATCH (v:SchemaVersion {name:$name}) RETURN v.version as version`,
+		Parameters: map[string]interface{}{
+			"name": schemaVersionName,
+		},
+		Result: &results,
+	}
+
+	if err := s.writeDirect(context.Background(), []*neoism.CypherQuery{query}); err != nil {
+		return 0, err
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0].Version, nil
+}
+
+// PendingMigrations returns the migrations newer than the schema version of the graph
+func (s cypherStore) PendingMigrations() ([]Migration, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+var (
+	createIndex      = regexp.MustCompile(`^CREATE INDEX ON :(\w+)\((\w+)\)$`)
+	createConstraint = regexp.MustCompile(`^CREATE CONSTRAINT ON \(\w+:(\w+)\) ASSERT \w+\.(\w+) IS UNIQUE$`)
+)
+
+// schemaExists is true if the statement creates an index or constraint that is already described
+func schemaExists(statement string, indexDescriptions []string, constraintDescriptions []string) bool {
+	if match := createIndex.FindStringSubmatch(statement); match != nil {
+		return len(missingSchema(indexDescriptions, map[string]string{match[1]: match[2]}, indexFormat, "index")) == 0
+	}
+	if match := createConstraint.FindStringSubmatch(statement); match != nil {
+		return len(missingSchema(constraintDescriptions, map[string]string{match[1]: match[2]}, constraintFormat, "constraint")) == 0
+	}
+	return false
+}
+
+// Migrate applies the pending migrations in order, recording the schema version after each one
+func (s cypherStore) Migrate() (int, error) {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	for i, m := range pending {
+		logEntry(context.Background(), "migrate", "", start).WithFields(log.Fields{"version": m.Version, "description": m.Description}).Info("Applying schema migration")
+
+		indexDescriptions, constraintDescriptions, err := s.readSchema()
+		if err != nil {
+			return i, err
+		}
+		for _, statement := range m.Statements {
+			if schemaExists(statement, indexDescriptions, constraintDescriptions) {
+				logEntry(context.Background(), "migrate", "", start).WithField("statement", statement).Info("Skipping schema the graph already has")
+				continue
+			}
+			if err := s.writeDirect(context.Background(), []*neoism.CypherQuery{{Statement: statement}}); err != nil {
+				return i, err
+			}
+		}
+
+		recordVersion := &neoism.CypherQuery{
+			Statement: `MERGE (v:SchemaVersion {name:$name})
+				SET v.version = $version, v.description = $description, v.migratedAt = $migratedAt`,
+			Parameters: map[string]interface{}{
+				"name":        schemaVersionName,
+				"version":     m.Version,
+				"description": m.Description,
+				"migratedAt":  time.Now().UTC().Format(time.RFC3339),
+			},
+		}
+		if err := s.writeDirect(context.Background(), []*neoism.CypherQuery{recordVersion}); err != nil {
+			return i, err
+		}
+	}
+
+	return len(pending), nil
+}
@@ -0,0 +1,96 @@
+package memberships
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrationsAreInVersionOrder(t *testing.T) {
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "Migration %q is out of order", m.Description)
+		assert.NotEmpty(t, m.Statements, "Migration %q has no statements", m.Description)
+	}
+}
+
+func TestMigrationsCreateTheExpectedSchema(t *testing.T) {
+	var descriptions []string
+	for _, m := range migrations {
+		descriptions = append(descriptions, m.Statements...)
+	}
+
+	assert.Empty(t, missingSchema(descriptions, indexes, "INDEXON"+indexFormat, "index"))
+	for label, property := range constraints {
+		assert.Empty(t, missingSchema(descriptions, map[string]string{label: property}, "CONSTRAINTON(.*"+constraintFormat, "constraint"),
+			fmt.Sprintf("No migration creates the constraint on %s", label))
+	}
+}
+
+// existingSchemaConnection is a Neo4j 4 graph that has the schema of every migration but no schema version, as the
+// graphs written before there were migrations do. Like Neo4j 4, it fails to create an index or constraint twice.
+type existingSchemaConnection struct {
+	fakeConnection
+	version *int
+	created *[]string
+}
+
+func (c existingSchemaConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	for _, q := range queries {
+		switch {
+		case q.Statement == "CALL db.indexes()":
+			rows, _ := json.Marshal([]schemaRow{{LabelsOrTypes: []string{"Identifier"}, Properties: []string{"value"}}})
+			json.Unmarshal(rows, q.Result)
+		case q.Statement == "CALL db.constraints()":
+			rows := []schemaRow{}
+			for label, property := range constraints {
+				rows = append(rows, schemaRow{Description: fmt.Sprintf("CONSTRAINT ON ( n:%s ) ASSERT (n.%s) IS UNIQUE", label, property)})
+			}
+			b, _ := json.Marshal(rows)
+			json.Unmarshal(b, q.Result)
+		case strings.HasPrefix(q.Statement, "MATCH (v:SchemaVersion"):
+			if *c.version > 0 {
+				json.Unmarshal([]byte(fmt.Sprintf(`[{"version":%d}]`, *c.version)), q.Result)
+			}
+		case strings.HasPrefix(q.Statement, "MERGE (v:SchemaVersion"):
+			*c.version = q.Parameters["version"].(int)
+		case strings.HasPrefix(q.Statement, "CREATE INDEX"), strings.HasPrefix(q.Statement, "CREATE CONSTRAINT"):
+			*c.created = append(*c.created, q.Statement)
+			return errors.New("Neo.ClientError.Schema.EquivalentSchemaRuleAlreadyExists: An equivalent index already exists")
+		}
+	}
+	return nil
+}
+
+func TestMigrateSkipsTheSchemaTheGraphAlreadyHas(t *testing.T) {
+	assert := assert.New(t)
+	version, created, batched := 0, []string{}, [][]*neoism.CypherQuery{}
+	store := NewCypherStore(recordingConnection{batches: &batched}).WithDirectConnection(existingSchemaConnection{version: &version, created: &created})
+
+	applied, err := store.Migrate()
+	assert.NoError(err)
+	assert.Equal(len(migrations), applied)
+	assert.Empty(created)
+	assert.Equal(len(migrations), version)
+	assert.Empty(batched, "Nothing is run in the batches shared with other statements")
+
+	applied, err = store.Migrate()
+	assert.NoError(err)
+	assert.Equal(0, applied, "Nothing is pending the second time")
+	assert.Empty(created)
+}
+
+func TestSchemaExists(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(schemaExists("CREATE INDEX ON :Identifier(value)", []string{"INDEX ON :Identifier(value)"}, nil))
+	assert.False(schemaExists("CREATE INDEX ON :Identifier(value)", []string{"INDEX ON :Identifier(uuid)"}, nil))
+	assert.True(schemaExists("CREATE CONSTRAINT ON (t:Thing) ASSERT t.uuid IS UNIQUE", nil,
+		[]string{"CONSTRAINT ON ( thing:Thing ) ASSERT thing.uuid IS UNIQUE"}))
+	assert.False(schemaExists("CREATE CONSTRAINT ON (t:Thing) ASSERT t.uuid IS UNIQUE", nil,
+		[]string{"CONSTRAINT ON ( concept:Concept ) ASSERT concept.uuid IS UNIQUE"}))
+	assert.False(schemaExists("MATCH (n) SET n.x = 1", nil, nil), "Only indexes and constraints are looked for")
+}
@@ -1,14 +1,28 @@
 package memberships
 
+// The structs below are the JSON payload the service reads and writes. Its JSON Schema, served at
+// /__schema/membership, is derived from them: a jsonschema:"required" tag makes a field required,
+// jsonschema:"nonempty" makes a string one that cannot be empty, and jsonschema:"date" makes it a date, in full or
+// partial.
+
 type membership struct {
-	UUID                   string                 `json:"uuid"`
+	UUID                   string                 `json:"uuid" jsonschema:"required"`
 	PrefLabel              string                 `json:"prefLabel,omitempty"`
-	PersonUUID             string                 `json:"personUuid"`
-	OrganisationUUID       string                 `json:"organisationUuid"`
-	InceptionDate          string                 `json:"inceptionDate,omitempty"`
-	TerminationDate        string                 `json:"terminationDate,omitempty"`
+	PersonUUID             string                 `json:"personUuid" jsonschema:"required,nonempty"`
+	OrganisationUUID       string                 `json:"organisationUuid" jsonschema:"required,nonempty"`
+	InceptionDate          string                 `json:"inceptionDate,omitempty" jsonschema:"date"`
+	TerminationDate        string                 `json:"terminationDate,omitempty" jsonschema:"date"`
 	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers"`
 	MembershipRoles        []role                 `json:"membershipRoles"`
+	Source                 *source                `json:"source,omitempty"`
+}
+
+// source records where a membership's data came from
+type source struct {
+	Authority  string `json:"authority" jsonschema:"required"`
+	System     string `json:"system,omitempty"`
+	RecordID   string `json:"recordId,omitempty"`
+	IngestedAt string `json:"ingestedAt,omitempty" jsonschema:"date"`
 }
 
 type alternativeIdentifiers struct {
@@ -22,7 +36,12 @@ const (
 )
 
 type role struct {
-	RoleUUID        string `json:"roleuuid,omitempty"`
-	InceptionDate   string `json:"inceptionDate,omitempty"`
-	TerminationDate string `json:"terminationDate,omitempty"`
+	RoleUUID string   `json:"roleuuid,omitempty" jsonschema:"required"`
+	Periods  []period `json:"periods"`
+}
+
+// period is a span of time a role was held. A role held more than once has a period for each time.
+type period struct {
+	InceptionDate   string `json:"inceptionDate,omitempty" jsonschema:"date"`
+	TerminationDate string `json:"terminationDate,omitempty" jsonschema:"date"`
 }
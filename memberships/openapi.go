@@ -0,0 +1,92 @@
+package memberships
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// APIPath is where the OpenAPI document of the service is served
+const APIPath = "/__api"
+
+//go:embed openapi.yaml
+var apiSpec []byte
+
+var apiDocument = mustLoadAPIDocument()
+
+// APIDocument returns the OpenAPI 3 document of every route the service serves, with the schemas of the
+// membership and its roles derived from the same types as the JSON Schema at SchemaPath, and that of the
+// membership as JSON-LD from linkedMembership
+func APIDocument() map[string]interface{} {
+	return apiDocument
+}
+
+func mustLoadAPIDocument() map[string]interface{} {
+	doc := map[string]interface{}{}
+	if err := yaml.Unmarshal(apiSpec, &doc); err != nil {
+		panic(err)
+	}
+
+	roleBody := schemaOf(reflect.TypeOf(role{}))
+	// The role's uuid is taken from the path when the body leaves it out
+	delete(roleBody, "required")
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	schemas["Membership"] = openAPISchema(MembershipSchema())
+	schemas["Role"] = openAPISchema(schemaOf(reflect.TypeOf(role{})))
+	schemas["RoleBody"] = openAPISchema(roleBody)
+	schemas["LinkedMembership"] = openAPISchema(schemaOf(reflect.TypeOf(linkedMembership{})))
+	return doc
+}
+
+// openAPISchema converts a JSON Schema to the subset OpenAPI 3.0 uses, in which a type is a single name and may
+// be made nullable rather than listing null as another type
+func openAPISchema(schema map[string]interface{}) map[string]interface{} {
+	converted := map[string]interface{}{}
+	for k, v := range schema {
+		switch k {
+		case "$schema":
+		case "type":
+			types, ok := v.([]string)
+			if !ok {
+				converted[k] = v
+				continue
+			}
+			for _, t := range types {
+				if t == "null" {
+					converted["nullable"] = true
+				} else {
+					converted[k] = t
+				}
+			}
+		case "properties":
+			properties := map[string]interface{}{}
+			for name, p := range v.(map[string]interface{}) {
+				properties[name] = openAPISchema(p.(map[string]interface{}))
+			}
+			converted[k] = properties
+		case "items", "not":
+			converted[k] = openAPISchema(v.(map[string]interface{}))
+		case "anyOf":
+			subschemas := []interface{}{}
+			for _, s := range v.([]interface{}) {
+				subschemas = append(subschemas, openAPISchema(s.(map[string]interface{})))
+			}
+			converted[k] = subschemas
+		default:
+			converted[k] = v
+		}
+	}
+	return converted
+}
+
+// APIHandler responds with the OpenAPI document of the service
+func APIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(APIDocument())
+}
@@ -0,0 +1,207 @@
+package memberships
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/stretchr/testify/assert"
+)
+
+// servedElsewhere are the operations in the API document whose handlers are not in this package, so are not
+// requested by the conformance test
+var servedElsewhere = map[string]bool{
+	"logLevel":        true,
+	"setLogLevel":     true,
+	"health":          true,
+	"goodToGo":        true,
+	"ping":            true,
+	"legacyPing":      true,
+	"buildInfo":       true,
+	"legacyBuildInfo": true,
+	"metrics":         true,
+}
+
+func init() {
+	openapi3filter.RegisterBodyDecoder(MergePatchType, openapi3filter.RegisteredBodyDecoder("application/json"))
+	openapi3filter.RegisterBodyDecoder(JSONLDType, openapi3filter.RegisteredBodyDecoder("application/json"))
+	openapi3filter.RegisterBodyDecoder("application/schema+json", openapi3filter.RegisteredBodyDecoder("application/json"))
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", ndjsonBodyDecoder)
+}
+
+// ndjsonBodyDecoder reads newline delimited JSON as a list, one item per line
+func ndjsonBodyDecoder(body io.Reader, _ http.Header, _ *openapi3.SchemaRef, _ openapi3filter.EncodingFn) (interface{}, error) {
+	items := []interface{}{}
+	lines := bufio.NewScanner(body)
+	for lines.Scan() {
+		var item interface{}
+		if err := json.Unmarshal(lines.Bytes(), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, lines.Err()
+}
+
+func TestAPIDocumentIsValidOpenAPI(t *testing.T) {
+	w := httptest.NewRecorder()
+	APIHandler(w, httptest.NewRequest("GET", APIPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	doc, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, doc.Validate(ctx))
+}
+
+// conformanceServer serves the handlers of this package as main does, checking that every request and response
+// matches the API document
+type conformanceServer struct {
+	t         *testing.T
+	server    *httptest.Server
+	router    routers.Router
+	exercised map[string]bool
+}
+
+func newConformanceServer(t *testing.T) *conformanceServer {
+	router, err := gorillamux.NewRouter(loadAPIDocument(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := NewCachingStore(NewMemoryStore(), CacheConfig{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMembershipService(cache)
+	neo := NewCypherStore(fakeConnection{})
+
+	mux := newTestMux(s)
+	mux.HandleFunc("GET /memberships/__export", s.ExportHandler)
+	mux.HandleFunc("POST /memberships/__batch-read", s.BatchReadHandler)
+	mux.HandleFunc("POST "+InvalidatePath, cache.InvalidateHandler)
+	mux.HandleFunc("GET "+SchemaPath, SchemaHandler)
+	mux.HandleFunc("GET "+APIPath, APIHandler)
+	mux.HandleFunc("/__integrity", neo.IntegrityHandler)
+	epochJob := NewEpochJob(neo, 0)
+	mux.HandleFunc("GET /__admin/recompute-epochs", epochJob.Handler)
+	mux.HandleFunc("POST /__admin/recompute-epochs", epochJob.Handler)
+
+	c := &conformanceServer{t: t, server: httptest.NewServer(mux), router: router, exercised: map[string]bool{}}
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+// do sends the request, failing the test if either it or its response does not match the document, and
+// returns the response status and body
+func (c *conformanceServer) do(method string, path string, accept string, contentType string, body string) (int, []byte) {
+	req, err := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	route, params, err := c.router.FindRoute(req)
+	if err != nil {
+		c.t.Fatalf("%s %s is not in the API document: %s", method, path, err)
+	}
+	c.exercised[route.Operation.OperationID] = true
+	input := &openapi3filter.RequestValidationInput{Request: req, PathParams: params, Route: route}
+	assert.NoError(c.t, openapi3filter.ValidateRequest(ctx, input), "%s %s %s", method, path, body)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	err = openapi3filter.ValidateResponse(ctx, &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 resp.StatusCode,
+		Header:                 resp.Header,
+		Body:                   io.NopCloser(bytes.NewReader(respBody)),
+		Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+	})
+	assert.NoError(c.t, err, "%s %s responded %d %s", method, path, resp.StatusCode, respBody)
+	return resp.StatusCode, respBody
+}
+
+// loadAPIDocument loads the document APIHandler serves
+func loadAPIDocument(t *testing.T) *openapi3.T {
+	b, _ := json.Marshal(APIDocument())
+	doc, err := openapi3.NewLoader().LoadFromData(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestRequestsConformToTheAPIDocument(t *testing.T) {
+	assert := assert.New(t)
+	c := newConformanceServer(t)
+	path := "/memberships/" + membershipUUID
+	rolePath := path + "/roles/" + roleUUID
+	full, _ := json.Marshal(fullMembership)
+
+	for _, r := range []struct {
+		method      string
+		path        string
+		accept      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"GET", path, "", "", "", http.StatusNotFound},
+		{"PUT", path, "", "application/json", string(full), http.StatusOK},
+		{"GET", path, "", "", "", http.StatusOK},
+		{"GET", path, JSONLDType, "", "", http.StatusOK},
+		{"PATCH", path, "", MergePatchType, `{"prefLabel":"Chief Executive"}`, http.StatusOK},
+		{"PATCH", path, "", JSONPatchType, `[{"op":"remove","path":"/prefLabel"}]`, http.StatusOK},
+		{"PATCH", path, "", MergePatchType, `{"personUuid":null}`, http.StatusUnprocessableEntity},
+		{"GET", rolePath, "", "", "", http.StatusOK},
+		{"PUT", rolePath, "", "application/json", `{"periods":[{"inceptionDate":"2008"}]}`, http.StatusOK},
+		{"PUT", rolePath, "", "application/json", `{"inceptionDate":"2008-01-01T00:00:00Z","terminationDate":"2010-06"}`, http.StatusOK},
+		{"DELETE", rolePath, "", "", "", http.StatusNoContent},
+		{"DELETE", rolePath, "", "", "", http.StatusNotFound},
+		{"GET", "/memberships/__count", "", "", "", http.StatusOK},
+		{"POST", "/memberships/__batch-read", "", "application/json", `{"uuids":["` + membershipUUID + `","` + newOrgUUID + `"]}`, http.StatusOK},
+		{"GET", "/memberships/__export?since=2000-01-01T00:00:00Z", "", "", "", http.StatusOK},
+		{"POST", InvalidatePath + "?uuid=" + membershipUUID, "", "", "", http.StatusNoContent},
+		{"DELETE", path, "", "", "", http.StatusNoContent},
+		{"DELETE", path, "", "", "", http.StatusNotFound},
+		{"GET", SchemaPath, "", "", "", http.StatusOK},
+		{"GET", APIPath, "", "", "", http.StatusOK},
+		{"GET", "/__integrity?limit=10", "", "", "", http.StatusOK},
+		{"GET", "/__admin/recompute-epochs", "", "", "", http.StatusOK},
+		{"POST", "/__admin/recompute-epochs", "", "", "", http.StatusAccepted},
+	} {
+		status, body := c.do(r.method, r.path, r.accept, r.contentType, r.body)
+		assert.Equal(r.status, status, "%s %s responded %s", r.method, r.path, body)
+	}
+
+	for path, item := range loadAPIDocument(t).Paths {
+		for method, op := range item.Operations() {
+			if !servedElsewhere[op.OperationID] {
+				assert.True(c.exercised[op.OperationID], "%s %s is not requested by the conformance test", method, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package memberships
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// The media types of the patches PATCH accepts
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// errUnsupportedPatchType is returned for a patch in a media type other than those above
+var errUnsupportedPatchType = fmt.Errorf("patches must be %s or %s", MergePatchType, JSONPatchType)
+
+// badPatchError is a patch that is malformed, or cannot be applied to the membership
+type badPatchError struct {
+	err error
+}
+
+func (e badPatchError) Error() string {
+	return "could not apply the patch: " + e.err.Error()
+}
+
+// invalidMembershipError is a patch that would leave the membership invalid
+type invalidMembershipError struct {
+	err error
+}
+
+func (e invalidMembershipError) Error() string {
+	return "the patched membership is invalid: " + e.err.Error()
+}
+
+// Patch applies a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902), according to its media type, to the
+// membership as a GET returns it, and writes the result back if it is still a valid membership. It returns the
+// membership as written, and whether there was one to patch.
+func (s service) Patch(ctx context.Context, uuid string, mediaType string, patch []byte) (membership, bool, error) {
+	var apply func(doc []byte) ([]byte, error)
+	switch mediaType {
+	case MergePatchType:
+		if !json.Valid(patch) {
+			return membership{}, false, badPatchError{errors.New("the merge patch is not JSON")}
+		}
+		apply = func(doc []byte) ([]byte, error) {
+			return jsonpatch.MergePatch(doc, patch)
+		}
+	case JSONPatchType:
+		ops, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return membership{}, false, badPatchError{err}
+		}
+		apply = ops.Apply
+	default:
+		return membership{}, false, errUnsupportedPatchType
+	}
+
+	return s.store.Update(ctx, uuid, func(current membership) (membership, error) {
+		doc, err := json.Marshal(current)
+		if err != nil {
+			return membership{}, err
+		}
+		patched, err := apply(doc)
+		if err != nil {
+			return membership{}, badPatchError{err}
+		}
+
+		if err := validateAgainstSchema(patched); err != nil {
+			return membership{}, invalidMembershipError{err}
+		}
+		// A patch of a property the membership does not have would otherwise be dropped without a word, as the
+		// schema allows other properties for the clients that send them in a PUT
+		m := membership{}
+		dec := json.NewDecoder(bytes.NewReader(patched))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&m); err != nil {
+			return membership{}, invalidMembershipError{err}
+		}
+		if m.UUID != uuid {
+			return membership{}, invalidMembershipError{errors.New("the uuid cannot be changed")}
+		}
+		m.MembershipRoles = normaliseRoles(m.MembershipRoles)
+		if err := validateMembership(m); err != nil {
+			return membership{}, invalidMembershipError{err}
+		}
+		return m, nil
+	})
+}
+
+// validateMembership checks what the schema cannot: that the membership's dates parse, that each role has a uuid
+// and periods that do not overlap, and that any source names its authority
+func validateMembership(m membership) error {
+	if err := validateDate("inceptionDate", m.InceptionDate); err != nil {
+		return err
+	}
+	if err := validateDate("terminationDate", m.TerminationDate); err != nil {
+		return err
+	}
+	for i, r := range m.MembershipRoles {
+		if err := validateRole(fmt.Sprintf("membershipRoles[%d]", i), r); err != nil {
+			return err
+		}
+	}
+	return validateSource(m)
+}
+
+// validateRole checks that the role, named in any error as name, has a uuid, that its dates parse and that its
+// periods do not overlap
+func validateRole(name string, r role) error {
+	if r.RoleUUID == "" {
+		return fmt.Errorf("%s needs a roleuuid", name)
+	}
+	for i, p := range r.Periods {
+		periodName := fmt.Sprintf("%s.periods[%d]", name, i)
+		if err := validateDate(periodName+".inceptionDate", p.InceptionDate); err != nil {
+			return err
+		}
+		if err := validateDate(periodName+".terminationDate", p.TerminationDate); err != nil {
+			return err
+		}
+	}
+	return validatePeriods(name, r)
+}
+
+// validateDate checks that the date is empty or parses, so that its epoch can be calculated
+func validateDate(name string, date string) error {
+	if date == "" {
+		return nil
+	}
+	if _, _, err := parseDate(date); err != nil {
+		return fmt.Errorf("%s %s", name, err)
+	}
+	return nil
+}
+
+// PatchHandler applies the patch in the body to the membership, responding with the membership as written.
+// The patch's media type is given by its Content-Type.
+func (s service) PatchHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m, found, err := s.Patch(ctx, uuid, mediaType, patch)
+	var badPatch badPatchError
+	var invalid invalidMembershipError
+	switch {
+	case err == errUnsupportedPatchType:
+		writeJSONMessage(w, http.StatusUnsupportedMediaType, err.Error())
+	case errors.As(err, &badPatch):
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &invalid):
+		writeJSONMessage(w, http.StatusUnprocessableEntity, err.Error())
+	case err == ErrConflict, err == ErrLowerPrioritySource:
+		writeJSONMessage(w, http.StatusConflict, err.Error())
+	case err != nil:
+		logEntry(ctx, "patch", uuid, start).WithError(err).Error("Could not patch membership")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+	case !found:
+		writeJSONMessage(w, http.StatusNotFound, "Membership not found.")
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		json.NewEncoder(w).Encode(m)
+	}
+}
@@ -0,0 +1,144 @@
+package memberships
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrentlyWrittenConnection reads fullMembership back, and finds on every guarded write that its revision has
+// moved on, as if it had been written in between
+type concurrentlyWrittenConnection struct {
+	fakeConnection
+	writes *int
+}
+
+func (c concurrentlyWrittenConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	for _, q := range queries {
+		switch tracing.StatementName(q) {
+		case "read membership revision":
+			json.Unmarshal([]byte(`[{"revision":2}]`), q.Result)
+		case "read membership":
+			read, _ := json.Marshal([]membership{fullMembership})
+			json.Unmarshal(read, q.Result)
+		case "lock membership":
+			*c.writes++
+			json.Unmarshal([]byte(`[{"locked":0}]`), q.Result)
+		}
+	}
+	return nil
+}
+
+func TestCypherUpdateGivesUpOnAMembershipThatKeepsBeingWritten(t *testing.T) {
+	assert := assert.New(t)
+	writes := 0
+	store := NewCypherStore(concurrentlyWrittenConnection{writes: &writes})
+
+	changes := 0
+	_, found, err := store.Update(ctx, membershipUUID, func(m membership) (membership, error) {
+		changes++
+		assert.Equal(fullMembership.PrefLabel, m.PrefLabel)
+		return m, nil
+	})
+	assert.Equal(ErrConflict, err)
+	assert.True(found)
+	assert.Equal(maxUpdateAttempts, changes)
+	assert.Equal(maxUpdateAttempts, writes)
+}
+
+// recordingConnection reads fullMembership back at revision 2, locks it, and keeps every batch it is sent
+type recordingConnection struct {
+	fakeConnection
+	batches *[][]*neoism.CypherQuery
+}
+
+func (c recordingConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	*c.batches = append(*c.batches, queries)
+	return concurrentlyWrittenConnection{writes: new(int)}.CypherBatch(queries)
+}
+
+func TestCypherUpdateWritesOnTheDirectConnectionOnlyIfTheRevisionIsUnchanged(t *testing.T) {
+	assert := assert.New(t)
+	batched, direct := [][]*neoism.CypherQuery{}, [][]*neoism.CypherQuery{}
+	store := NewCypherStore(recordingConnection{batches: &batched}).WithDirectConnection(recordingConnection{batches: &direct})
+
+	store.Update(ctx, membershipUUID, func(m membership) (membership, error) { return m, nil })
+	assert.Empty(batched, "Nothing is run in the batches shared with other writes")
+	if !assert.Len(direct, 2*maxUpdateAttempts) {
+		return
+	}
+
+	write := direct[1]
+	assert.Equal("lock membership", tracing.StatementName(write[0]))
+	assert.Contains(write[0].Statement, "SET m._lock = true")
+	assert.EqualValues(2, write[0].Parameters["revision"])
+	for _, q := range write[1 : len(write)-1] {
+		assert.Contains(q.Statement, "MATCH (:MembershipUpdate {uuid:$pendinguuid})", "%s is guarded", tracing.StatementName(q))
+		assert.Equal(membershipUUID, q.Parameters["pendinguuid"])
+	}
+	assert.Equal("unlock membership", tracing.StatementName(write[len(write)-1]))
+}
+
+func TestValidateMembership(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(validateMembership(fullMembership))
+
+	m := fullMembership
+	m.MembershipRoles = []role{{RoleUUID: roleUUID, Periods: []period{{TerminationDate: "2006-13"}}}}
+	assert.EqualError(validateMembership(m), `membershipRoles[0].periods[0].terminationDate "2006-13" is not an RFC 3339 date, or a year, month or day such as 2005-06`)
+
+	m.MembershipRoles = []role{{Periods: []period{{InceptionDate: "2006-01-01T00:00:00Z"}}}}
+	assert.EqualError(validateMembership(m), "membershipRoles[0] needs a roleuuid")
+
+	m.MembershipRoles = []role{{RoleUUID: roleUUID, Periods: []period{
+		{"2006-01-01T00:00:00Z", "2008-01-01T00:00:00Z"},
+		{"2007-01-01T00:00:00Z", ""},
+	}}}
+	assert.EqualError(validateMembership(m), "membershipRoles[0] has overlapping periods")
+
+	m.MembershipRoles[0].Periods[1].InceptionDate = "2008-01-01T00:00:00Z"
+	assert.NoError(validateMembership(m), "A period may start when the last one ends")
+}
+
+func TestRoleReadsPeriodsOrASingleInceptionAndTerminationDate(t *testing.T) {
+	assert := assert.New(t)
+
+	r := role{}
+	assert.NoError(json.Unmarshal([]byte(`{"roleuuid":"r","inceptionDate":"2006-01-01T00:00:00Z"}`), &r))
+	assert.Equal(role{"r", []period{{InceptionDate: "2006-01-01T00:00:00Z"}}}, r)
+
+	r = role{}
+	assert.NoError(json.Unmarshal([]byte(`{"roleuuid":"r","periods":[{"terminationDate":"2006-01-01T00:00:00Z"}]}`), &r))
+	assert.Equal(role{"r", []period{{TerminationDate: "2006-01-01T00:00:00Z"}}}, r)
+
+	r = role{}
+	assert.NoError(json.Unmarshal([]byte(`{"roleuuid":"r","periods":[{"inceptionDate":"2008"}],"inceptionDate":"2006"}`), &r))
+	assert.Equal(role{"r", []period{{InceptionDate: "2008"}}}, r, "The periods win over the dates written for old clients")
+}
+
+func TestRoleWritesTheDatesOfItsLatestPeriodForOldClients(t *testing.T) {
+	assert := assert.New(t)
+
+	b, err := json.Marshal(role{"r", []period{{"2006", "2008"}, {"2012", ""}}})
+	assert.NoError(err)
+	assert.JSONEq(`{"roleuuid":"r","periods":[{"inceptionDate":"2006","terminationDate":"2008"},{"inceptionDate":"2012"}],"inceptionDate":"2012"}`, string(b))
+
+	b, err = json.Marshal(role{"r", []period{{"2006", "2008"}}})
+	assert.NoError(err)
+	assert.JSONEq(`{"roleuuid":"r","periods":[{"inceptionDate":"2006","terminationDate":"2008"}],"inceptionDate":"2006","terminationDate":"2008"}`, string(b))
+}
+
+func TestNormaliseRolesMergesAndOrdersPeriods(t *testing.T) {
+	roles := normaliseRoles([]role{
+		{"b", []period{{"2010-01-01T00:00:00Z", ""}}},
+		{"a", nil},
+		{"b", []period{{"2006-01-01T00:00:00.000Z", "2008-01-01T00:00:00Z"}, {"", "2001-01-01T00:00:00Z"}}},
+	})
+	assert.Equal(t, []role{
+		{"a", []period{{}}},
+		{"b", []period{{"", "2001-01-01T00:00:00Z"}, {"2006-01-01T00:00:00.000Z", "2008-01-01T00:00:00Z"}, {"2010-01-01T00:00:00Z", ""}}},
+	}, roles)
+}
@@ -0,0 +1,134 @@
+package memberships
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MarshalJSON writes the role with its periods, and with the inceptionDate and terminationDate of the latest of
+// them, which is the shape clients written before there were periods read
+func (r role) MarshalJSON() ([]byte, error) {
+	out := struct {
+		RoleUUID        string   `json:"roleuuid,omitempty"`
+		Periods         []period `json:"periods"`
+		InceptionDate   string   `json:"inceptionDate,omitempty"`
+		TerminationDate string   `json:"terminationDate,omitempty"`
+	}{RoleUUID: r.RoleUUID, Periods: r.Periods}
+	if len(r.Periods) > 0 {
+		latest := r.Periods[len(r.Periods)-1]
+		out.InceptionDate, out.TerminationDate = latest.InceptionDate, latest.TerminationDate
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads a role with periods, or one with a single inceptionDate and terminationDate, the shape
+// the transformer and exports from before periods existed send, as a role with that one period. The
+// inceptionDate and terminationDate of a role with periods are ignored, as MarshalJSON only adds them for old
+// clients.
+func (r *role) UnmarshalJSON(data []byte) error {
+	in := struct {
+		RoleUUID        string   `json:"roleuuid"`
+		Periods         []period `json:"periods"`
+		InceptionDate   string   `json:"inceptionDate"`
+		TerminationDate string   `json:"terminationDate"`
+	}{}
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+
+	r.RoleUUID = in.RoleUUID
+	r.Periods = in.Periods
+	if r.Periods == nil {
+		r.Periods = []period{{InceptionDate: in.InceptionDate, TerminationDate: in.TerminationDate}}
+	}
+	return nil
+}
+
+// normaliseRoles merges the roles with the same uuid into one with all their periods, as the graph has a HAS_ROLE
+// relationship for each period. Roles are ordered by uuid and their periods by inception date, and a role with no
+// periods is given one with no dates, as it is still written as a HAS_ROLE relationship.
+func normaliseRoles(roles []role) []role {
+	index := map[string]int{}
+	merged := []role{}
+	for _, r := range roles {
+		if i, ok := index[r.RoleUUID]; ok {
+			merged[i].Periods = append(merged[i].Periods, r.Periods...)
+			continue
+		}
+		index[r.RoleUUID] = len(merged)
+		merged = append(merged, role{RoleUUID: r.RoleUUID, Periods: append([]period{}, r.Periods...)})
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].RoleUUID < merged[j].RoleUUID
+	})
+	for i := range merged {
+		if len(merged[i].Periods) == 0 {
+			merged[i].Periods = []period{{}}
+		}
+		sortPeriods(merged[i].Periods)
+	}
+	return merged
+}
+
+// sortPeriods orders periods by when they start, then by when they end, with an unknown inception date first.
+// Dates that do not parse are ordered as text after those that do.
+func sortPeriods(periods []period) {
+	sort.SliceStable(periods, func(i, j int) bool {
+		a, b := periods[i], periods[j]
+		if a.InceptionDate != b.InceptionDate {
+			return dateBefore(a.InceptionDate, b.InceptionDate, math.MinInt64)
+		}
+		return dateBefore(a.TerminationDate, b.TerminationDate, math.MaxInt64)
+	})
+}
+
+// dateBefore compares two dates by their epochs, taking an empty date to be at the given epoch
+func dateBefore(a string, b string, empty int64) bool {
+	ea, errA := periodEpoch(a, empty)
+	eb, errB := periodEpoch(b, empty)
+	switch {
+	case errA != nil && errB != nil:
+		return a < b
+	case errA != nil || errB != nil:
+		return errB != nil
+	}
+	return ea < eb
+}
+
+func periodEpoch(date string, empty int64) (int64, error) {
+	if date == "" {
+		return empty, nil
+	}
+	return epochOf(date)
+}
+
+// validatePeriods checks that no two of the role's periods overlap. A period with no inception date is taken to have
+// started before the others, and one with no termination date to be ongoing. A period may start when another ends.
+// Periods with dates that do not parse are left out, as there is no telling whether they overlap.
+func validatePeriods(name string, r role) error {
+	type span struct{ start, end int64 }
+	spans := []span{}
+	for _, p := range r.Periods {
+		start, err := periodEpoch(p.InceptionDate, math.MinInt64)
+		if err != nil {
+			continue
+		}
+		end, err := periodEpoch(p.TerminationDate, math.MaxInt64)
+		if err != nil {
+			continue
+		}
+		spans = append(spans, span{start, end})
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	for i := 1; i < len(spans); i++ {
+		if spans[i-1].end > spans[i].start {
+			return fmt.Errorf("%s has overlapping periods", name)
+		}
+	}
+	return nil
+}
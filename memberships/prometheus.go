@@ -0,0 +1,255 @@
+package memberships
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds the Prometheus metrics of the service. Register them once, then instrument the store and the
+// neo4j connection with them.
+type Metrics struct {
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	statements      *prometheus.CounterVec
+	callDuration    *prometheus.HistogramVec
+	neoErrors       *prometheus.CounterVec
+}
+
+// NewMetrics creates the metrics and registers them with registerer
+func NewMetrics(registerer prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memberships_requests_total",
+			Help: "Store operations by operation and outcome, which is ok, not_found or error",
+		}, []string{"operation", "outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memberships_request_duration_seconds",
+			Help:    "Time taken by store operations",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"operation"}),
+		statements: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memberships_cypher_statements_total",
+			Help: "Cypher statements sent to neo4j, by access mode",
+		}, []string{"mode"}),
+		callDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "memberships_cypher_call_duration_seconds",
+			Help:    "Time callers wait for their Cypher statements, by access mode and what is timed",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"mode", "timed"}),
+		neoErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "memberships_neo4j_errors_total",
+			Help: "Failed Cypher batches, by neo4j status code, or unknown if the error has none, e.g. a connection failure",
+		}, []string{"type"}),
+	}
+	registerer.MustRegister(m.requests, m.requestDuration, m.statements, m.callDuration, m.neoErrors)
+	return m
+}
+
+// RegisterCountGauge registers a gauge of the number of memberships in store, counted when it is scraped
+func RegisterCountGauge(registerer prometheus.Registerer, store MembershipStore) {
+	registerer.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "memberships_count",
+		Help: "Number of memberships in the store",
+	}, func() float64 {
+		start := time.Now()
+		count, err := store.Count(context.Background())
+		if err != nil {
+			logEntry(context.Background(), "count", "", start).WithError(err).Warn("Could not count memberships for the memberships_count gauge")
+			return math.NaN()
+		}
+		return float64(count)
+	}))
+}
+
+func (m *Metrics) observe(operation string, start time.Time, found bool, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	} else if !found {
+		outcome = "not_found"
+	}
+	m.requests.WithLabelValues(operation, outcome).Inc()
+	m.requestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// instrumentedStore counts and times the operations of the store it wraps
+type instrumentedStore struct {
+	MembershipStore
+	metrics *Metrics
+}
+
+// InstrumentStore counts and times the operations of store. Wrap it around any cache, so that the metrics
+// cover every request.
+func (m *Metrics) InstrumentStore(store MembershipStore) MembershipStore {
+	return instrumentedStore{store, m}
+}
+
+func (s instrumentedStore) Read(ctx context.Context, uuid string) (membership, bool, error) {
+	start := time.Now()
+	result, found, err := s.MembershipStore.Read(ctx, uuid)
+	s.metrics.observe("read", start, found, err)
+	return result, found, err
+}
+
+func (s instrumentedStore) ReadMany(ctx context.Context, uuids []string) (map[string]membership, error) {
+	start := time.Now()
+	found, err := s.MembershipStore.ReadMany(ctx, uuids)
+	s.metrics.observe("read_many", start, true, err)
+	return found, err
+}
+
+func (s instrumentedStore) ReadLabels(ctx context.Context, uuids []string) (map[string]string, error) {
+	start := time.Now()
+	labels, err := s.MembershipStore.ReadLabels(ctx, uuids)
+	s.metrics.observe("read_labels", start, true, err)
+	return labels, err
+}
+
+func (s instrumentedStore) ReadPage(ctx context.Context, after string, since time.Time, limit int) ([]membership, string, error) {
+	start := time.Now()
+	page, next, err := s.MembershipStore.ReadPage(ctx, after, since, limit)
+	s.metrics.observe("read_page", start, true, err)
+	return page, next, err
+}
+
+func (s instrumentedStore) Write(ctx context.Context, m membership) error {
+	start := time.Now()
+	err := s.MembershipStore.Write(ctx, m)
+	s.metrics.observe("write", start, true, err)
+	return err
+}
+
+func (s instrumentedStore) WriteMany(ctx context.Context, ms []membership) error {
+	start := time.Now()
+	err := s.MembershipStore.WriteMany(ctx, ms)
+	s.metrics.observe("write_many", start, true, err)
+	return err
+}
+
+func (s instrumentedStore) Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error) {
+	start := time.Now()
+	m, found, err := s.MembershipStore.Update(ctx, uuid, change)
+	s.metrics.observe("update", start, found, err)
+	return m, found, err
+}
+
+func (s instrumentedStore) Create(ctx context.Context, m membership) (bool, error) {
+	start := time.Now()
+	created, err := s.MembershipStore.Create(ctx, m)
+	s.metrics.observe("create", start, true, err)
+	return created, err
+}
+
+func (s instrumentedStore) Delete(ctx context.Context, uuid string) (bool, error) {
+	start := time.Now()
+	found, err := s.MembershipStore.Delete(ctx, uuid)
+	s.metrics.observe("delete", start, found, err)
+	return found, err
+}
+
+func (s instrumentedStore) DeleteIf(ctx context.Context, uuid string, check func(membership) error) (bool, error) {
+	start := time.Now()
+	found, err := s.MembershipStore.DeleteIf(ctx, uuid, check)
+	s.metrics.observe("delete", start, found, err)
+	return found, err
+}
+
+func (s instrumentedStore) ReadRole(ctx context.Context, uuid string, roleUUID string) (role, bool, error) {
+	start := time.Now()
+	r, found, err := s.MembershipStore.ReadRole(ctx, uuid, roleUUID)
+	s.metrics.observe("read_role", start, found, err)
+	return r, found, err
+}
+
+func (s instrumentedStore) WriteRole(ctx context.Context, uuid string, r role) (bool, error) {
+	start := time.Now()
+	found, err := s.MembershipStore.WriteRole(ctx, uuid, r)
+	s.metrics.observe("write_role", start, found, err)
+	return found, err
+}
+
+func (s instrumentedStore) DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error) {
+	start := time.Now()
+	found, err := s.MembershipStore.DeleteRole(ctx, uuid, roleUUID)
+	s.metrics.observe("delete_role", start, found, err)
+	return found, err
+}
+
+func (s instrumentedStore) Count(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := s.MembershipStore.Count(ctx)
+	s.metrics.observe("count", start, true, err)
+	return count, err
+}
+
+// instrumentedConnection counts and times the Cypher statements sent through the connection it wraps
+type instrumentedConnection struct {
+	neoutils.NeoConnection
+	metrics *Metrics
+	timed   string
+}
+
+// InstrumentConnection counts and times the Cypher statements sent through conn, and counts its errors. Set merged
+// if conn merges each batch with other callers' before sending it, so that its time is labelled as the wait for the
+// merged batch rather than that of the statements.
+func (m *Metrics) InstrumentConnection(conn neoutils.NeoConnection, merged bool) neoutils.NeoConnection {
+	timed := "statements"
+	if merged {
+		timed = "merged_batch_wait"
+	}
+	return instrumentedConnection{conn, m, timed}
+}
+
+// readRunner is implemented by connections that can send read-only queries to a different cluster member than writes
+type readRunner interface {
+	CypherReadBatch(queries []*neoism.CypherQuery) error
+}
+
+func (c instrumentedConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherBatchContext(context.Background(), queries)
+}
+
+func (c instrumentedConnection) CypherReadBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherReadBatchContext(context.Background(), queries)
+}
+
+func (c instrumentedConnection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run("write", queries, func(queries []*neoism.CypherQuery) error {
+		return tracing.Forward(ctx, c.NeoConnection, false, queries)
+	})
+}
+
+func (c instrumentedConnection) CypherReadBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run("read", queries, func(queries []*neoism.CypherQuery) error {
+		return tracing.Forward(ctx, c.NeoConnection, true, queries)
+	})
+}
+
+func (c instrumentedConnection) run(mode string, queries []*neoism.CypherQuery, run func([]*neoism.CypherQuery) error) error {
+	start := time.Now()
+	err := run(queries)
+	c.metrics.statements.WithLabelValues(mode).Add(float64(len(queries)))
+	c.metrics.callDuration.WithLabelValues(mode, c.timed).Observe(time.Since(start).Seconds())
+	if err != nil {
+		c.metrics.neoErrors.WithLabelValues(neoErrorType(err)).Inc()
+	}
+	return err
+}
+
+var neoStatusCode = regexp.MustCompile(`Neo\.[A-Za-z]+\.[A-Za-z]+\.[A-Za-z]+`)
+
+// neoErrorType returns the neo4j status code in err, such as Neo.ClientError.Schema.ConstraintValidationFailed,
+// which both the REST API and Bolt include in their errors
+func neoErrorType(err error) string {
+	if code := neoStatusCode.FindString(err.Error()); code != "" {
+		return code
+	}
+	return "unknown"
+}
@@ -0,0 +1,71 @@
+package memberships
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConnection fails every batch with err, or succeeds if it is nil
+type fakeConnection struct {
+	err error
+}
+
+func (c fakeConnection) CypherBatch(queries []*neoism.CypherQuery) error       { return c.err }
+func (c fakeConnection) EnsureConstraints(constraints map[string]string) error { return nil }
+func (c fakeConnection) EnsureIndexes(indexes map[string]string) error         { return nil }
+
+func TestInstrumentedStoreCountsOperationsByOutcome(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	backing := NewMemoryStore()
+	RegisterCountGauge(registry, backing)
+	store := metrics.InstrumentStore(backing)
+
+	assert.NoError(store.Write(ctx, fullMembership))
+	store.Read(ctx, membershipUUID)
+	store.Read(ctx, "missing")
+	store.Delete(ctx, "missing")
+
+	assert.Equal(1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("write", "ok")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("read", "ok")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("read", "not_found")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.requests.WithLabelValues("delete", "not_found")))
+	assert.Equal(3, testutil.CollectAndCount(metrics.requestDuration), "Read, write and delete latencies")
+
+	count, err := testutil.GatherAndCount(registry, "memberships_count")
+	assert.NoError(err)
+	assert.Equal(1, count)
+}
+
+func TestInstrumentedConnectionCountsStatementsAndErrors(t *testing.T) {
+	assert := assert.New(t)
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry)
+	queries := []*neoism.CypherQuery{{Statement: "RETURN 1"}, {Statement: "RETURN 2"}}
+
+	ok := metrics.InstrumentConnection(fakeConnection{}, false)
+	assert.NoError(ok.CypherBatch(queries))
+	assert.NoError(ok.(readRunner).CypherReadBatch(queries[:1]))
+
+	failing := metrics.InstrumentConnection(fakeConnection{errors.New("Neo.ClientError.Schema.ConstraintValidationFailed: Node already exists")}, true)
+	assert.Error(failing.CypherBatch(queries))
+
+	assert.Equal(4.0, testutil.ToFloat64(metrics.statements.WithLabelValues("write")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.statements.WithLabelValues("read")))
+	assert.Equal(1.0, testutil.ToFloat64(metrics.neoErrors.WithLabelValues("Neo.ClientError.Schema.ConstraintValidationFailed")))
+
+	count, err := testutil.GatherAndCount(registry, "memberships_cypher_call_duration_seconds")
+	assert.NoError(err)
+	assert.Equal(3, count, "Writes and reads timed alone, and writes timed in a merged batch")
+}
+
+func TestNeoErrorType(t *testing.T) {
+	assert.Equal(t, "Neo.TransientError.Cluster.NotALeader", neoErrorType(errors.New("Server error: [Neo.TransientError.Cluster.NotALeader] No write operations are allowed")))
+	assert.Equal(t, "unknown", neoErrorType(errors.New("dial tcp 127.0.0.1:7687: connect: connection refused")))
+}
@@ -0,0 +1,126 @@
+package memberships
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/bolt"
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+// QueryLogConfig sets which batches of Cypher statements LogQueries logs
+type QueryLogConfig struct {
+	// SlowThreshold is how long a batch may take before its statements are logged, zero logs none
+	SlowThreshold time.Duration
+	// ProfileEvery runs one in every ProfileEvery batches with PROFILE, zero profiles none. Only Bolt
+	// connections can profile statements.
+	ProfileEvery int
+	// Merged is set for a connection that merges each batch with other callers' before sending it, so that the
+	// time logged is how long the caller waited for the merged batch rather than how long its statements took
+	Merged bool
+}
+
+// batchIDs numbers the batches logged, so that the lines logged for the statements of one batch can be told apart
+var batchIDs uint64
+
+// queryLogger logs the slow batches of Cypher statements sent through the connection it wraps
+type queryLogger struct {
+	neoutils.NeoConnection
+	conf    QueryLogConfig
+	batches uint64
+}
+
+// LogQueries logs the statements of each batch sent through conn that takes longer than the threshold, with their
+// parameter values redacted, and profiles a sample of the batches, logging the db hits of each statement
+func LogQueries(conn neoutils.NeoConnection, conf QueryLogConfig) neoutils.NeoConnection {
+	return &queryLogger{NeoConnection: conn, conf: conf}
+}
+
+func (c *queryLogger) CypherBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherBatchContext(context.Background(), queries)
+}
+
+func (c *queryLogger) CypherReadBatch(queries []*neoism.CypherQuery) error {
+	return c.CypherReadBatchContext(context.Background(), queries)
+}
+
+func (c *queryLogger) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run(ctx, "cypher_write", false, queries)
+}
+
+func (c *queryLogger) CypherReadBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	return c.run(ctx, "cypher_read", true, queries)
+}
+
+func (c *queryLogger) run(ctx context.Context, operation string, read bool, queries []*neoism.CypherQuery) error {
+	start := time.Now()
+	batchID := atomic.AddUint64(&batchIDs, 1)
+	if c.sample() {
+		ctx = bolt.WithProfile(ctx, func(q *neoism.CypherQuery, dbHits int64) {
+			logEntry(ctx, operation, "", start).WithFields(statementFields(q)).WithFields(log.Fields{
+				"batch_id": batchID,
+				"db_hits":  dbHits,
+			}).Info("Profiled Cypher statement")
+		})
+	}
+
+	err := tracing.Forward(ctx, c.NeoConnection, read, queries)
+
+	if c.conf.SlowThreshold > 0 && time.Since(start) >= c.conf.SlowThreshold {
+		message, timed := "Slow Cypher batch", "statements"
+		if c.conf.Merged {
+			message, timed = "Slow wait for a merged Cypher batch", "merged_batch_wait"
+		}
+		for i, q := range queries {
+			logEntry(ctx, operation, "", start).WithFields(statementFields(q)).WithFields(log.Fields{
+				"batch_id":        batchID,
+				"timed":           timed,
+				"statement_index": i,
+				"statement_count": len(queries),
+			}).Warn(message)
+		}
+	}
+	return err
+}
+
+// sample is true for one in every ProfileEvery batches
+func (c *queryLogger) sample() bool {
+	if c.conf.ProfileEvery <= 0 {
+		return false
+	}
+	return atomic.AddUint64(&c.batches, 1)%uint64(c.conf.ProfileEvery) == 0
+}
+
+func statementFields(q *neoism.CypherQuery) log.Fields {
+	return log.Fields{
+		"statement_name": tracing.StatementName(q),
+		"statement":      q.Statement,
+		"parameters":     redact(q.Parameters),
+	}
+}
+
+// redact replaces each parameter value with its type, and the length of lists and maps, so that the logs show
+// the shape of a statement's parameters without the data in them
+func redact(params map[string]interface{}) map[string]string {
+	redacted := make(map[string]string, len(params))
+	for name, value := range params {
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Slice, reflect.Array:
+			redacted[name] = fmt.Sprintf("<list of %d>", v.Len())
+		case reflect.Map:
+			redacted[name] = fmt.Sprintf("<map of %d>", v.Len())
+		case reflect.Invalid:
+			redacted[name] = "<null>"
+		default:
+			redacted[name] = fmt.Sprintf("<%T>", value)
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,77 @@
+package memberships
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogQueriesLogsSlowBatches(t *testing.T) {
+	assert := assert.New(t)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	queries := []*neoism.CypherQuery{
+		{Statement: "// read membership\nMATCH (m:Membership {uuid:$uuid}) RETURN m", Parameters: map[string]interface{}{"uuid": membershipUUID}},
+		{Statement: "RETURN 1"},
+	}
+
+	fast := LogQueries(fakeConnection{}, QueryLogConfig{SlowThreshold: time.Hour})
+	assert.NoError(fast.CypherBatch(queries))
+	assert.Empty(hook.AllEntries())
+
+	slow := LogQueries(fakeConnection{}, QueryLogConfig{SlowThreshold: time.Nanosecond})
+	assert.NoError(slow.(readRunner).CypherReadBatch(queries))
+	entries := hook.AllEntries()
+	if assert.Len(entries, 2, "One line per statement") {
+		assert.Equal(log.WarnLevel, entries[0].Level)
+		assert.Equal("cypher_read", entries[0].Data["operation"])
+		assert.Equal("read membership", entries[0].Data["statement_name"])
+		assert.Equal(map[string]string{"uuid": "<string>"}, entries[0].Data["parameters"])
+		assert.NotContains(entries[0].Message+entries[0].Data["statement"].(string), membershipUUID)
+		assert.Equal("statements", entries[0].Data["timed"])
+		assert.Equal(entries[0].Data["batch_id"], entries[1].Data["batch_id"], "Both statements are in one batch")
+	}
+	first := hook.LastEntry().Data["batch_id"]
+
+	hook.Reset()
+	merged := LogQueries(fakeConnection{}, QueryLogConfig{SlowThreshold: time.Nanosecond, Merged: true})
+	assert.NoError(merged.CypherBatch(queries))
+	entries = hook.AllEntries()
+	if assert.Len(entries, 2) {
+		assert.Equal("Slow wait for a merged Cypher batch", entries[0].Message)
+		assert.Equal("merged_batch_wait", entries[0].Data["timed"])
+		assert.NotEqual(first, entries[0].Data["batch_id"])
+	}
+}
+
+func TestLogQueriesProfilesOneInEveryProfileEveryBatches(t *testing.T) {
+	c := LogQueries(fakeConnection{}, QueryLogConfig{ProfileEvery: 3}).(*queryLogger)
+	sampled := 0
+	for i := 0; i < 9; i++ {
+		if c.sample() {
+			sampled++
+		}
+	}
+	assert.Equal(t, 3, sampled)
+	assert.False(t, LogQueries(fakeConnection{}, QueryLogConfig{}).(*queryLogger).sample())
+}
+
+func TestRedactKeepsTheShapeOfParameters(t *testing.T) {
+	assert.Equal(t, map[string]string{
+		"uuid":  "<string>",
+		"limit": "<int>",
+		"uuids": "<list of 2>",
+		"props": "<map of 1>",
+		"since": "<null>",
+	}, redact(map[string]interface{}{
+		"uuid":  membershipUUID,
+		"limit": 10,
+		"uuids": []string{"a", "b"},
+		"props": neoism.Props{"prefLabel": "Secret"},
+		"since": nil,
+	}))
+}
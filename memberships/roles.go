@@ -0,0 +1,95 @@
+package memberships
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// The handlers below work on a single role of a membership, at e.g. "GET /memberships/{uuid}/roles/{roleUuid}",
+// so that a role can be added, changed or ended without sending the whole membership.
+
+// ReadRoleHandler responds with the role, or 404 if the membership has no role with the role uuid
+func (s service) ReadRoleHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	rl, found, err := s.store.ReadRole(ctx, uuid, r.PathValue("roleUuid"))
+	if err != nil {
+		logEntry(ctx, "read_role", uuid, start).WithError(err).Error("Could not read role")
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Role not found.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(rl)
+}
+
+// WriteRoleHandler adds the role in the body to the membership, or replaces the role it has with the same uuid.
+// The body may leave out the roleuuid, which is taken from the path, but may not give a different one.
+func (s service) WriteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	roleUUID := r.PathValue("roleUuid")
+
+	rl := role{}
+	if err := json.NewDecoder(r.Body).Decode(&rl); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if rl.RoleUUID == "" {
+		rl.RoleUUID = roleUUID
+	}
+	if rl.RoleUUID != roleUUID {
+		writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("Role uuids from payload and request, respectively, do not match: '%v' '%v'", rl.RoleUUID, roleUUID))
+		return
+	}
+	if err := validateRole("role", rl); err != nil {
+		writeJSONMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	found, err := s.store.WriteRole(ctx, uuid, rl)
+	if err != nil {
+		logEntry(ctx, "write_role", uuid, start).WithError(err).Error("Could not write role")
+		if err == ErrConflict || err == ErrLowerPrioritySource {
+			writeJSONMessage(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Membership not found.")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteRoleHandler removes the role from the membership, responding 404 if the membership does not have it
+func (s service) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	ctx := requestContext(r)
+	uuid := r.PathValue("uuid")
+	found, err := s.store.DeleteRole(ctx, uuid, r.PathValue("roleUuid"))
+	if err != nil {
+		logEntry(ctx, "delete_role", uuid, start).WithError(err).Error("Could not delete role")
+		if err == ErrConflict || err == ErrLowerPrioritySource {
+			writeJSONMessage(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONMessage(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if !found {
+		writeJSONMessage(w, http.StatusNotFound, "Role not found.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
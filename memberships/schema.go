@@ -0,0 +1,135 @@
+package memberships
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// SchemaPath is where the JSON Schema of the membership payload is served
+const SchemaPath = "/__schema/membership"
+
+// partialDatePattern matches the dates parseDate reads as just a year, month or day. The days are checked against
+// the month, but not the year, so 29 February is left to validateMembership to reject outside a leap year.
+const partialDatePattern = `^[0-9]{4}(-(0[1-9]|1[0-2])|-(0[13578]|1[02])-(0[1-9]|[12][0-9]|3[01])|-(0[469]|11)-(0[1-9]|[12][0-9]|30)|-02-(0[1-9]|1[0-9]|2[0-9]))?$`
+
+// schemaExtender is implemented by types whose JSON accepts more than their fields, to add it to their schema
+type schemaExtender interface {
+	extendSchema(schema map[string]interface{})
+}
+
+// MembershipSchema returns the JSON Schema of the membership payload, derived from the membership type
+func MembershipSchema() map[string]interface{} {
+	schema := schemaOf(reflect.TypeOf(membership{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "Membership"
+	return schema
+}
+
+// schemaOf returns the schema of a type's JSON. Structs are objects of the fields with a json tag, and slices are
+// arrays or null. Other properties are allowed, as json.Unmarshal ignores them and clients written before there was a
+// schema send them.
+func schemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem())
+	case reflect.Slice:
+		// A nil slice is written as null
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			property := schemaOf(f.Type)
+			for _, option := range strings.Split(f.Tag.Get("jsonschema"), ",") {
+				switch option {
+				case "required":
+					required = append(required, name)
+				case "nonempty":
+					property["minLength"] = 1
+				case "date":
+					property = dateSchema()
+				}
+			}
+			properties[name] = property
+		}
+
+		schema := map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		if e, ok := reflect.Zero(t).Interface().(schemaExtender); ok {
+			e.extendSchema(schema)
+		}
+		return schema
+	}
+	panic(fmt.Sprintf("no JSON Schema for %s", t))
+}
+
+// dateSchema is an RFC 3339 date, or a partial one
+func dateSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "string",
+		"anyOf": []interface{}{
+			map[string]interface{}{"format": "date-time"},
+			map[string]interface{}{"pattern": partialDatePattern},
+		},
+	}
+}
+
+// extendSchema adds the single inceptionDate and terminationDate a role may have in place of periods, as
+// UnmarshalJSON reads them, and has alongside them as MarshalJSON writes it
+func (role) extendSchema(schema map[string]interface{}) {
+	properties := schema["properties"].(map[string]interface{})
+	properties["inceptionDate"] = dateSchema()
+	properties["terminationDate"] = dateSchema()
+}
+
+var membershipSchema = mustCompileSchema()
+
+func mustCompileSchema() *gojsonschema.Schema {
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(MembershipSchema()))
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// validateAgainstSchema checks a membership's JSON against its schema, returning an error listing where it does
+// not match
+func validateAgainstSchema(doc []byte) error {
+	result, err := membershipSchema.Validate(gojsonschema.NewBytesLoader(doc))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	problems := []string{}
+	for _, e := range result.Errors() {
+		problems = append(problems, e.String())
+	}
+	return fmt.Errorf("the membership does not match its schema: %s", strings.Join(problems, "; "))
+}
+
+// SchemaHandler responds with the JSON Schema of the membership payload
+func SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(MembershipSchema())
+}
@@ -0,0 +1,79 @@
+package memberships
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateSchema = flag.Bool("update-schema", false, "rewrite testdata/membership.schema.json from the membership type")
+
+const schemaFile = "testdata/membership.schema.json"
+
+// The checked in schema is the contract clients test against, so a change to the structs that changes it has to
+// change the file too
+func TestSchemaMatchesTheCheckedInCopy(t *testing.T) {
+	w := httptest.NewRecorder()
+	SchemaHandler(w, httptest.NewRequest("GET", SchemaPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+
+	if *updateSchema {
+		if err := os.WriteFile(schemaFile, w.Body.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkedIn, err := os.ReadFile(schemaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, string(checkedIn), w.Body.String(), "Run go test ./memberships -run TestSchema -update-schema to update it")
+}
+
+func TestSchemaAcceptsWhatTheServiceReturns(t *testing.T) {
+	assert := assert.New(t)
+	sourced := sourcedBy("FACTSET")
+	sourced.InceptionDate = "2005-06"
+
+	for _, m := range []membership{fullMembership, asRead(sourced), {UUID: membershipUUID, PersonUUID: personUUID, OrganisationUUID: orgUUID}} {
+		doc, _ := json.Marshal(m)
+		assert.NoError(validateAgainstSchema(doc), string(doc))
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	assert := assert.New(t)
+	valid := `"uuid":"` + membershipUUID + `","personUuid":"` + personUUID + `","organisationUuid":"` + orgUUID + `"`
+
+	assert.NoError(validateAgainstSchema([]byte(`{`+valid+`,"membershipRoles":[{"roleuuid":"r","inceptionDate":"2005"}]}`)), "A role with a single inception date")
+	assert.NoError(validateAgainstSchema([]byte(`{`+valid+`,"colour":"red"}`)), "Unknown properties are ignored")
+	for _, date := range []string{"2005", "2005-12", "2005-01-31", "2005-04-30", "2004-02-29"} {
+		assert.NoError(validateAgainstSchema([]byte(`{`+valid+`,"inceptionDate":"`+date+`"}`)), date)
+	}
+	for name, doc := range map[string]string{
+		"missing person":           `{"uuid":"` + membershipUUID + `","organisationUuid":"` + orgUUID + `"}`,
+		"empty organisation":       `{"uuid":"` + membershipUUID + `","personUuid":"` + personUUID + `","organisationUuid":""}`,
+		"bad date":                 `{` + valid + `,"inceptionDate":"yesterday"}`,
+		"month 13":                 `{` + valid + `,"inceptionDate":"2005-13"}`,
+		"31 February":              `{` + valid + `,"inceptionDate":"2005-02-31"}`,
+		"31 April":                 `{` + valid + `,"inceptionDate":"2005-04-31"}`,
+		"role without uuid":        `{` + valid + `,"membershipRoles":[{"periods":[]}]}`,
+		"source without authority": `{` + valid + `,"source":{"system":"s"}}`,
+	} {
+		assert.Error(validateAgainstSchema([]byte(doc)), name)
+	}
+}
+
+func TestDecodeJSONValidatesWhatTheSchemaCannot(t *testing.T) {
+	s := NewMembershipService(NewMemoryStore())
+	valid := `"uuid":"` + membershipUUID + `","personUuid":"` + personUUID + `","organisationUuid":"` + orgUUID + `"`
+
+	_, _, err := s.DecodeJSON(json.NewDecoder(strings.NewReader(`{` + valid + `,"inceptionDate":"2005-02-29"}`)))
+	assert.EqualError(t, err, `inceptionDate "2005-02-29" is not an RFC 3339 date, or a year, month or day such as 2005-06`)
+}
@@ -0,0 +1,166 @@
+package memberships
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrLowerPrioritySource is returned for a write from a source with a lower priority than the one that wrote the
+// membership, which is left as it is
+var ErrLowerPrioritySource = errors.New("the membership was written by a source with a higher priority")
+
+// SourcePolicy ranks the authorities memberships come from, e.g. FACTSET, by their priority
+type SourcePolicy struct {
+	// Priority lists the authorities from the highest priority to the lowest. Authorities not listed, and writes
+	// without a source, come after all of them.
+	Priority []string
+}
+
+// rank is higher the higher the priority of the source, and zero for one not listed
+func (p SourcePolicy) rank(s *source) int {
+	if s == nil {
+		return 0
+	}
+	for i, authority := range p.Priority {
+		if strings.EqualFold(authority, s.Authority) {
+			return len(p.Priority) - i
+		}
+	}
+	return 0
+}
+
+// allows is true if a write from incoming may overwrite what current wrote
+func (p SourcePolicy) allows(current *source, incoming *source) bool {
+	return p.rank(incoming) >= p.rank(current)
+}
+
+// sourcePolicyStore rejects writes that would overwrite a membership written by a source with a higher priority
+type sourcePolicyStore struct {
+	MembershipStore
+	policy SourcePolicy
+}
+
+// EnforceSourcePolicy puts the policy in front of the writes to store. Deletes and role writes carry no source,
+// so they are only allowed on memberships whose source has the lowest priority.
+func EnforceSourcePolicy(store MembershipStore, policy SourcePolicy) MembershipStore {
+	return sourcePolicyStore{store, policy}
+}
+
+// errNoSuchRole stops an Update that would remove a role the membership does not have
+var errNoSuchRole = errors.New("the membership has no such role")
+
+// Write replaces the membership through Update, so that the source checked is the one overwritten. If there is
+// none it is created, unless another write created it first, in which case its source is checked in turn.
+func (s sourcePolicyStore) Write(ctx context.Context, m membership) error {
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		_, found, err := s.MembershipStore.Update(ctx, m.UUID, func(current membership) (membership, error) {
+			if !s.policy.allows(current.Source, m.Source) {
+				return membership{}, ErrLowerPrioritySource
+			}
+			return m, nil
+		})
+		if err != nil || found {
+			return err
+		}
+		created, err := s.MembershipStore.Create(ctx, m)
+		if err != nil || created {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// Delete deletes the membership through DeleteIf, so that the source checked is that of the membership deleted
+func (s sourcePolicyStore) Delete(ctx context.Context, uuid string) (bool, error) {
+	return s.MembershipStore.DeleteIf(ctx, uuid, func(current membership) error {
+		if !s.policy.allows(current.Source, nil) {
+			return ErrLowerPrioritySource
+		}
+		return nil
+	})
+}
+
+// WriteRole writes the role through Update, checking the stored source in the same guarded write
+func (s sourcePolicyStore) WriteRole(ctx context.Context, uuid string, r role) (bool, error) {
+	_, found, err := s.MembershipStore.Update(ctx, uuid, func(current membership) (membership, error) {
+		if !s.policy.allows(current.Source, nil) {
+			return membership{}, ErrLowerPrioritySource
+		}
+		current.MembershipRoles = append(withoutRole(current.MembershipRoles, r.RoleUUID), r)
+		return current, nil
+	})
+	return found, err
+}
+
+// DeleteRole removes the role through Update, checking the stored source in the same guarded write
+func (s sourcePolicyStore) DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error) {
+	_, found, err := s.MembershipStore.Update(ctx, uuid, func(current membership) (membership, error) {
+		if !s.policy.allows(current.Source, nil) {
+			return membership{}, ErrLowerPrioritySource
+		}
+		roles := withoutRole(current.MembershipRoles, roleUUID)
+		if len(roles) == len(current.MembershipRoles) {
+			return membership{}, errNoSuchRole
+		}
+		current.MembershipRoles = roles
+		return current, nil
+	})
+	if err == errNoSuchRole {
+		return false, nil
+	}
+	return found, err
+}
+
+// WriteMany fails the whole batch if any membership in it may not be overwritten. The check is not in the same
+// transaction as the write, which is left to Write: the importer writes a failed batch a membership at a time.
+func (s sourcePolicyStore) WriteMany(ctx context.Context, ms []membership) error {
+	uuids := make([]string, len(ms))
+	for i, m := range ms {
+		uuids[i] = m.UUID
+	}
+	current, err := s.MembershipStore.ReadMany(ctx, uuids)
+	if err != nil {
+		return err
+	}
+	for _, m := range ms {
+		if c, ok := current[m.UUID]; ok && !s.policy.allows(c.Source, m.Source) {
+			return fmt.Errorf("%w: %s", ErrLowerPrioritySource, m.UUID)
+		}
+	}
+	return s.MembershipStore.WriteMany(ctx, ms)
+}
+
+func (s sourcePolicyStore) Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error) {
+	return s.MembershipStore.Update(ctx, uuid, func(current membership) (membership, error) {
+		updated, err := change(current)
+		if err != nil {
+			return membership{}, err
+		}
+		if !s.policy.allows(current.Source, updated.Source) {
+			return membership{}, ErrLowerPrioritySource
+		}
+		return updated, nil
+	})
+}
+
+// validateSource checks that a membership's source, if it has one, names its authority and has an ingestion time
+// that parses
+func validateSource(m membership) error {
+	if m.Source == nil {
+		return nil
+	}
+	if m.Source.Authority == "" {
+		return errors.New("source.authority is required")
+	}
+	return validateDate("source.ingestedAt", m.Source.IngestedAt)
+}
+
+// ingestedNow sets the ingestion time of a membership's source to now, unless it already has one
+func ingestedNow(m *membership) {
+	if m.Source != nil && m.Source.IngestedAt == "" {
+		m.Source.IngestedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,168 @@
+package memberships
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+func sourcedBy(authority string) membership {
+	m := fullMembership
+	m.Source = &source{Authority: authority, IngestedAt: "2017-01-01T00:00:00Z"}
+	return m
+}
+
+func TestSourcePolicyRanksListedAuthoritiesFirst(t *testing.T) {
+	assert := assert.New(t)
+	policy := SourcePolicy{Priority: []string{"MANUAL", "FACTSET"}}
+
+	assert.True(policy.allows(nil, nil))
+	assert.True(policy.allows(nil, &source{Authority: "OTHER"}))
+	assert.True(policy.allows(&source{Authority: "FACTSET"}, &source{Authority: "manual"}), "Authorities are not case sensitive")
+	assert.True(policy.allows(&source{Authority: "FACTSET"}, &source{Authority: "FACTSET"}))
+	assert.False(policy.allows(&source{Authority: "MANUAL"}, &source{Authority: "FACTSET"}))
+	assert.False(policy.allows(&source{Authority: "FACTSET"}, &source{Authority: "OTHER"}))
+	assert.False(policy.allows(&source{Authority: "FACTSET"}, nil))
+}
+
+func TestSourcePolicyStoreKeepsHigherPriorityWrites(t *testing.T) {
+	assert := assert.New(t)
+	backing := NewMemoryStore()
+	store := EnforceSourcePolicy(backing, SourcePolicy{Priority: []string{"MANUAL", "FACTSET"}})
+
+	assert.NoError(store.Write(ctx, sourcedBy("FACTSET")))
+	assert.NoError(store.Write(ctx, sourcedBy("MANUAL")))
+	assert.Equal(ErrLowerPrioritySource, store.Write(ctx, sourcedBy("FACTSET")))
+	assert.Equal(ErrLowerPrioritySource, store.Write(ctx, fullMembership), "A write without a source has the lowest priority")
+	assert.True(errors.Is(store.WriteMany(ctx, []membership{sourcedBy("FACTSET")}), ErrLowerPrioritySource))
+
+	_, _, err := store.Update(ctx, membershipUUID, func(m membership) (membership, error) {
+		m.Source = &source{Authority: "FACTSET"}
+		return m, nil
+	})
+	assert.Equal(ErrLowerPrioritySource, err)
+
+	m, _, err := store.Read(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.Equal("MANUAL", m.Source.Authority)
+
+	other := sourcedBy("FACTSET")
+	other.UUID = newOrgUUID
+	assert.NoError(store.WriteMany(ctx, []membership{other}), "Nothing to overwrite")
+}
+
+func TestSourcePolicyStoreChecksDeletesAndRoleWrites(t *testing.T) {
+	assert := assert.New(t)
+	backing := NewMemoryStore()
+	store := EnforceSourcePolicy(backing, SourcePolicy{Priority: []string{"MANUAL", "FACTSET"}})
+	assert.NoError(store.Write(ctx, sourcedBy("FACTSET")))
+
+	found, err := store.WriteRole(ctx, membershipUUID, role{RoleUUID: newOrgUUID})
+	assert.Equal(ErrLowerPrioritySource, err)
+	assert.True(found)
+	_, err = store.DeleteRole(ctx, membershipUUID, roleUUID)
+	assert.Equal(ErrLowerPrioritySource, err)
+	_, err = store.Delete(ctx, membershipUUID)
+	assert.Equal(ErrLowerPrioritySource, err)
+
+	m, found, err := backing.Read(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal(fullMembership.MembershipRoles, m.MembershipRoles)
+
+	other := fullMembership
+	other.UUID = newOrgUUID
+	assert.NoError(store.Write(ctx, other))
+	found, err = store.WriteRole(ctx, newOrgUUID, role{RoleUUID: newOrgUUID})
+	assert.NoError(err)
+	assert.True(found, "A membership without a source has the lowest priority")
+	found, err = store.DeleteRole(ctx, newOrgUUID, personUUID)
+	assert.NoError(err)
+	assert.False(found, "The membership has no such role")
+	found, err = store.DeleteRole(ctx, newOrgUUID, newOrgUUID)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = store.Delete(ctx, newOrgUUID)
+	assert.NoError(err)
+	assert.True(found)
+	found, err = store.Delete(ctx, newOrgUUID)
+	assert.NoError(err)
+	assert.False(found)
+}
+
+// createdFirstStore is created by another source just before each Create, as if that one raced it
+type createdFirstStore struct {
+	*memoryStore
+	first membership
+}
+
+func (s createdFirstStore) Create(c context.Context, m membership) (bool, error) {
+	s.memoryStore.Write(c, s.first)
+	return s.memoryStore.Create(c, m)
+}
+
+func TestSourcePolicyStoreChecksAMembershipCreatedFirst(t *testing.T) {
+	assert := assert.New(t)
+	backing := createdFirstStore{NewMemoryStore(), sourcedBy("MANUAL")}
+	store := EnforceSourcePolicy(backing, SourcePolicy{Priority: []string{"MANUAL", "FACTSET"}})
+
+	assert.Equal(ErrLowerPrioritySource, store.Write(ctx, sourcedBy("FACTSET")))
+	m, _, err := backing.Read(ctx, membershipUUID)
+	assert.NoError(err)
+	assert.Equal("MANUAL", m.Source.Authority)
+}
+
+func TestCypherCreateMergesTheMembershipInTheGuardedWrite(t *testing.T) {
+	assert := assert.New(t)
+	batched, direct := [][]*neoism.CypherQuery{}, [][]*neoism.CypherQuery{}
+	store := NewCypherStore(recordingConnection{batches: &batched}).WithDirectConnection(recordingConnection{batches: &direct})
+
+	created, err := store.Create(ctx, fullMembership)
+	assert.NoError(err)
+	assert.False(created, "The connection finds nothing to lock")
+	assert.Empty(batched)
+	if assert.Len(direct, 1) {
+		assert.Contains(direct[0][0].Statement, "MERGE (m:Thing {uuid:$uuid})")
+		assert.Contains(direct[0][1].Statement, "MATCH (:MembershipUpdate {uuid:$pendinguuid})")
+	}
+}
+
+func TestSourcePolicyDeleteIsGuardedOnTheRevisionChecked(t *testing.T) {
+	assert := assert.New(t)
+	batched, direct := [][]*neoism.CypherQuery{}, [][]*neoism.CypherQuery{}
+	cypher := NewCypherStore(recordingConnection{batches: &batched}).WithDirectConnection(recordingConnection{batches: &direct})
+	store := EnforceSourcePolicy(cypher, SourcePolicy{Priority: []string{"MANUAL"}})
+
+	found, err := store.Delete(ctx, membershipUUID)
+	assert.Equal(ErrConflict, err, "The connection finds the membership written after every check")
+	assert.True(found)
+	assert.Empty(batched)
+	if !assert.Len(direct, 2*maxUpdateAttempts) {
+		return
+	}
+
+	write := direct[1]
+	assert.Equal("lock membership", tracing.StatementName(write[0]))
+	assert.EqualValues(2, write[0].Parameters["revision"])
+	names := []string{}
+	for _, q := range write[1 : len(write)-1] {
+		names = append(names, tracing.StatementName(q))
+		assert.Contains(q.Statement, "MATCH (:MembershipUpdate {uuid:$pendinguuid})", "%s is guarded", tracing.StatementName(q))
+	}
+	assert.Equal([]string{"find membership", "clear membership", "remove unused node"}, names)
+}
+
+func TestValidateSource(t *testing.T) {
+	assert := assert.New(t)
+	assert.NoError(validateSource(fullMembership))
+	assert.NoError(validateSource(sourcedBy("FACTSET")))
+	assert.EqualError(validateSource(sourcedBy("")), "source.authority is required")
+
+	m := sourcedBy("FACTSET")
+	m.Source.IngestedAt = "yesterday"
+	assert.Error(validateSource(m))
+}
@@ -0,0 +1,49 @@
+package memberships
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned by Update when the membership kept being written by something else while it was updating it
+var ErrConflict = errors.New("the membership was changed by another write while updating it")
+
+// MembershipStore persists memberships for the service, which handles the decoding and the baseftrwapp interface.
+// The context carries the span to trace the operation under and the transaction id.
+type MembershipStore interface {
+	Initialise() error
+	Read(ctx context.Context, uuid string) (membership, bool, error)
+	// ReadMany returns the memberships found for any of the uuids, keyed by uuid
+	ReadMany(ctx context.Context, uuids []string) (map[string]membership, error)
+	// ReadLabels returns the prefLabel of each thing with one of the uuids, e.g. a person, organisation or role,
+	// keyed by uuid. Things without a prefLabel are left out.
+	ReadLabels(ctx context.Context, uuids []string) (map[string]string, error)
+	Write(ctx context.Context, m membership) error
+	// WriteMany writes the memberships together, leaving the store as writing each in turn would
+	WriteMany(ctx context.Context, ms []membership) error
+	// Update reads the membership and writes back what change returns, as long as nothing else has written it in
+	// between. It returns the membership as written, whether there was one to update, and the error from change if
+	// there is one.
+	Update(ctx context.Context, uuid string, change func(membership) (membership, error)) (membership, bool, error)
+	// Create writes the membership unless Read would find one with its uuid, returning whether it did. Nothing can
+	// be written between the check and the write, so of two creates of the same membership only one writes it.
+	Create(ctx context.Context, m membership) (bool, error)
+	Delete(ctx context.Context, uuid string) (bool, error)
+	// DeleteIf deletes the membership if check, given the membership as it is, returns no error, as long as nothing
+	// else writes it in between. It returns whether there was a membership, and the error from check if there is one.
+	DeleteIf(ctx context.Context, uuid string, check func(membership) error) (bool, error)
+	// ReadRole returns the membership's role with the role uuid, and whether it has one
+	ReadRole(ctx context.Context, uuid string, roleUUID string) (role, bool, error)
+	// WriteRole replaces the membership's role with the same role uuid as r, or adds r if it has none, leaving the
+	// rest of the membership as it is. It returns whether there is a membership with the uuid.
+	WriteRole(ctx context.Context, uuid string, r role) (bool, error)
+	// DeleteRole removes the role from the membership, returning whether the membership had it
+	DeleteRole(ctx context.Context, uuid string, roleUUID string) (bool, error)
+	Count(ctx context.Context) (int, error)
+	Check() error
+	// ReadPage returns up to limit memberships with a uuid after the given one, in uuid order,
+	// leaving out those last modified before since unless it is zero.
+	// The uuid to read the next page after is returned, or "" if this is the last page.
+	ReadPage(ctx context.Context, after string, since time.Time, limit int) ([]membership, string, error)
+}
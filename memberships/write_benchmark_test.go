@@ -0,0 +1,72 @@
+//go:build !jenkins
+// +build !jenkins
+
+package memberships
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+)
+
+const benchmarkBatchSize = 100
+
+// benchmarkMemberships returns n memberships of the same person in the same organisation, each with one role,
+// which is the shape of most of a bulk load
+func benchmarkMemberships(n int) []interface{} {
+	ms := make([]interface{}, n)
+	for i := range ms {
+		uuid := fmt.Sprintf("benchmark-%d", i)
+		m := fullMembership
+		m.UUID = uuid
+		m.AlternativeIdentifiers = alternativeIdentifiers{"FACTSET_" + uuid, []string{uuid}}
+		ms[i] = m
+	}
+	return ms
+}
+
+func cleanBenchmarkDB(db neoutils.NeoConnection, b *testing.B, assert *assert.Assertions) {
+	assert.NoError(db.CypherBatch([]*neoism.CypherQuery{{
+		Statement: `MATCH (m:Thing) WHERE m.uuid STARTS WITH 'benchmark-'
+			OPTIONAL MATCH (m)<-[:IDENTIFIES]-(i:Identifier)
+			DETACH DELETE m, i`,
+	}}))
+	cleanDB(db, b, assert)
+}
+
+// BenchmarkWrite writes a batch of memberships one at a time, as the PUT endpoint does
+func BenchmarkWrite(b *testing.B) {
+	assert := assert.New(b)
+	db := getDatabaseConnectionAndCheckClean(b, assert)
+	defer cleanBenchmarkDB(db, b, assert)
+	membershipDriver := getCypherDriver(db)
+	ms := benchmarkMemberships(benchmarkBatchSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, m := range ms {
+			if err := membershipDriver.Write(m, "TRANS_ID"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkWriteMany writes the same batch of memberships together, as the import does
+func BenchmarkWriteMany(b *testing.B) {
+	assert := assert.New(b)
+	db := getDatabaseConnectionAndCheckClean(b, assert)
+	defer cleanBenchmarkDB(db, b, assert)
+	membershipDriver := getCypherDriver(db)
+	ms := benchmarkMemberships(benchmarkBatchSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := membershipDriver.WriteMany(ms, "TRANS_ID"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
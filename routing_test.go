@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The go:debug line in main.go is what makes these patterns work when the service is built in GOPATH mode
+func TestRoutesMatchMethodsAndWildcards(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("GET /memberships/{uuid}/roles/{roleUuid}", traced("read role", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.PathValue("uuid") + " " + r.PathValue("roleUuid")))
+	}))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/memberships/m1/roles/r1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "m1 r1", w.Body.String())
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/memberships/m1/roles/r1", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
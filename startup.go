@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/memberships-rw-neo4j/tracing"
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	"github.com/Financial-Times/service-status-go/gtg"
+	"github.com/jmcvetta/neoism"
+	log "github.com/sirupsen/logrus"
+)
+
+var errNotConnected = errors.New("not connected to neo4j yet")
+
+// backoff configures how often and how quickly a startup step is retried
+type backoff struct {
+	attempts int
+	initial  time.Duration
+	max      time.Duration
+}
+
+// retry calls f until it succeeds or the attempts are used up, doubling the wait between attempts up to max
+func retry(step string, b backoff, f func() error) error {
+	wait := b.initial
+	var err error
+	for attempt := 1; attempt <= b.attempts; attempt++ {
+		if err = f(); err == nil {
+			return nil
+		}
+		if attempt == b.attempts {
+			break
+		}
+		log.WithError(err).WithFields(log.Fields{"step": step, "attempt": attempt, "retry_in": wait}).Warn("Startup step failed, retrying")
+		time.Sleep(wait)
+		if wait *= 2; wait > b.max {
+			wait = b.max
+		}
+	}
+	return fmt.Errorf("%s failed after %d attempts: %v", step, b.attempts, err)
+}
+
+// pendingConnection stands in for the Neo4j connection while startup is still connecting, failing every call until then
+type pendingConnection struct {
+	sync.RWMutex
+	neoURL string
+	conn   neoutils.NeoConnection
+}
+
+func (p *pendingConnection) connection() (neoutils.NeoConnection, error) {
+	p.RLock()
+	defer p.RUnlock()
+	if p.conn == nil {
+		return nil, errNotConnected
+	}
+	return p.conn, nil
+}
+
+func (p *pendingConnection) set(conn neoutils.NeoConnection) {
+	p.Lock()
+	defer p.Unlock()
+	p.conn = conn
+}
+
+func (p *pendingConnection) CypherBatch(queries []*neoism.CypherQuery) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	return conn.CypherBatch(queries)
+}
+
+// CypherReadBatch sends read-only queries to a follower or read replica when the connection can route them there
+func (p *pendingConnection) CypherReadBatch(queries []*neoism.CypherQuery) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	if r, ok := conn.(interface {
+		CypherReadBatch([]*neoism.CypherQuery) error
+	}); ok {
+		return r.CypherReadBatch(queries)
+	}
+	return conn.CypherBatch(queries)
+}
+
+// CypherBatchContext passes the trace context on to the connection
+func (p *pendingConnection) CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	return tracing.Forward(ctx, conn, false, queries)
+}
+
+// CypherReadBatchContext passes the trace context on to the connection, routing the queries as CypherReadBatch does
+func (p *pendingConnection) CypherReadBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	return tracing.Forward(ctx, conn, true, queries)
+}
+
+func (p *pendingConnection) EnsureConstraints(constraints map[string]string) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	return conn.EnsureConstraints(constraints)
+}
+
+func (p *pendingConnection) EnsureIndexes(indexes map[string]string) error {
+	conn, err := p.connection()
+	if err != nil {
+		return err
+	}
+	return conn.EnsureIndexes(indexes)
+}
+
+func (p *pendingConnection) String() string {
+	return p.neoURL
+}
+
+// startup connects to the store and initialises it in the background, retrying both with backoff
+type startup struct {
+	sync.RWMutex
+	ready bool
+	err   error
+}
+
+// run connects to the store and then initialises it, calling fail if either step runs out of attempts
+func (s *startup) run(connect func() error, initialise func() error, b backoff, fail func(error)) {
+	err := retry("connecting to the store", b, connect)
+	if err == nil {
+		err = retry("initialising the store", b, initialise)
+	}
+
+	s.Lock()
+	s.ready = err == nil
+	s.err = err
+	s.Unlock()
+
+	if err != nil {
+		fail(err)
+		return
+	}
+	log.Info("Connected to the store and initialised it")
+}
+
+// gtg reports not good to go until startup has completed, then defers to check
+func (s *startup) gtg(check func() error) gtg.StatusChecker {
+	return func() gtg.Status {
+		s.RLock()
+		ready, err := s.ready, s.err
+		s.RUnlock()
+
+		if err != nil {
+			return gtg.Status{GoodToGo: false, Message: err.Error()}
+		}
+		if !ready {
+			return gtg.Status{GoodToGo: false, Message: "still connecting to the store and initialising it"}
+		}
+		if err := check(); err != nil {
+			return gtg.Status{GoodToGo: false, Message: err.Error()}
+		}
+		return gtg.Status{GoodToGo: true}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryStopsOnSuccess(t *testing.T) {
+	calls := 0
+	err := retry("test", backoff{attempts: 5, initial: time.Millisecond, max: time.Millisecond}, func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryGivesUpAfterAttempts(t *testing.T) {
+	calls := 0
+	err := retry("test", backoff{attempts: 3, initial: time.Millisecond, max: 2 * time.Millisecond}, func() error {
+		calls++
+		return errors.New("neo4j is down")
+	})
+
+	assert.EqualError(t, err, "test failed after 3 attempts: neo4j is down")
+	assert.Equal(t, 3, calls)
+}
+
+func TestGTGNotReadyUntilStartupCompletes(t *testing.T) {
+	var st startup
+	check := st.gtg(func() error { return nil })
+	assert.False(t, check().GoodToGo)
+
+	db := &pendingConnection{neoURL: "http://localhost:7474/db/data"}
+	assert.Equal(t, errNotConnected, db.CypherBatch(nil))
+
+	var failed error
+	st.run(func() error { return nil }, func() error { return errors.New("schema") }, backoff{attempts: 1}, func(err error) { failed = err })
+
+	assert.EqualError(t, failed, "initialising the store failed after 1 attempts: schema")
+	assert.False(t, check().GoodToGo)
+}
+
+func TestGTGDefersToCheckOnceStarted(t *testing.T) {
+	var st startup
+	st.run(func() error { return nil }, func() error { return nil }, backoff{attempts: 1}, func(err error) { t.Fatal(err) })
+
+	assert.True(t, st.gtg(func() error { return nil })().GoodToGo)
+	assert.False(t, st.gtg(func() error { return errors.New("down") })().GoodToGo)
+}
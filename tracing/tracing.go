@@ -0,0 +1,147 @@
+// Package tracing traces Cypher statements with OpenTelemetry, whichever connection they are sent through
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Financial-Times/neo-utils-go/neoutils"
+	transactionidutils "github.com/Financial-Times/transactionid-utils-go"
+	"github.com/jmcvetta/neoism"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Financial-Times/memberships-rw-neo4j/tracing"
+
+// Attribute keys set on the spans
+const (
+	TransactionIDKey  = attribute.Key("transaction_id")
+	StatementNameKey  = attribute.Key("db.statement.name")
+	ParameterCountKey = attribute.Key("db.statement.parameter_count")
+	StatementCountKey = attribute.Key("db.statement_count")
+	AccessModeKey     = attribute.Key("db.neo4j.access_mode")
+)
+
+// ContextRunner is implemented by connections that trace each statement themselves, as they run it
+type ContextRunner interface {
+	CypherBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error
+	CypherReadBatchContext(ctx context.Context, queries []*neoism.CypherQuery) error
+}
+
+type readRunner interface {
+	CypherReadBatch(queries []*neoism.CypherQuery) error
+}
+
+// Tracer returns the tracer for spans around Cypher and HTTP requests
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Run runs the queries through conn in one transaction, traced by a span for the transaction with a child span
+// for each statement. The transaction is read only if read is true, so that a cluster may serve it from a follower.
+func Run(ctx context.Context, conn neoutils.CypherRunner, read bool, queries []*neoism.CypherQuery) error {
+	mode := "write"
+	if read {
+		mode = "read"
+	}
+	ctx, span := Tracer().Start(ctx, "neo4j "+mode+" transaction",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(commonAttributes(ctx), StatementCountKey.Int(len(queries)), AccessModeKey.String(mode))...))
+	defer span.End()
+
+	err := Forward(ctx, conn, read, queries)
+	End(span, err)
+	return err
+}
+
+// Forward runs the queries through conn within the transaction span in ctx. Connections that wrap another call
+// it to pass the context on. If conn cannot trace its own statements, each statement's span covers the whole
+// batch, as the statements in a batch cannot be timed individually.
+func Forward(ctx context.Context, conn neoutils.CypherRunner, read bool, queries []*neoism.CypherQuery) error {
+	if r, ok := conn.(ContextRunner); ok {
+		if read {
+			return r.CypherReadBatchContext(ctx, queries)
+		}
+		return r.CypherBatchContext(ctx, queries)
+	}
+
+	run := conn.CypherBatch
+	if r, ok := conn.(readRunner); ok && read {
+		run = r.CypherReadBatch
+	}
+
+	spans := make([]trace.Span, len(queries))
+	for i, q := range queries {
+		_, spans[i] = StartStatement(ctx, q)
+	}
+	err := run(queries)
+	for _, span := range spans {
+		End(span, err)
+	}
+	return err
+}
+
+// StartStatement starts the span for a single statement
+func StartStatement(ctx context.Context, q *neoism.CypherQuery) (context.Context, trace.Span) {
+	name := StatementName(q)
+	return Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(commonAttributes(ctx), StatementNameKey.String(name), ParameterCountKey.Int(len(q.Parameters)))...))
+}
+
+// End records err, if any, on span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// StatementName names a statement by the comment on its first line, e.g. "// create membership",
+// or by its first clause if it has no comment
+func StatementName(q *neoism.CypherQuery) string {
+	statement := strings.TrimSpace(q.Statement)
+	if strings.HasPrefix(statement, "//") {
+		firstLine := strings.SplitN(statement, "\n", 2)[0]
+		return strings.TrimSpace(strings.TrimPrefix(firstLine, "//"))
+	}
+	if fields := strings.Fields(statement); len(fields) > 0 {
+		return strings.ToUpper(fields[0])
+	}
+	return "empty statement"
+}
+
+func commonAttributes(ctx context.Context) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("db.system", "neo4j")}
+	if tid, err := transactionidutils.GetTransactionIDFromContext(ctx); err == nil {
+		attrs = append(attrs, TransactionIDKey.String(tid))
+	}
+	return attrs
+}
+
+// Configure exports spans to the OTLP/HTTP collector at endpoint, e.g. http://localhost:4318, and propagates
+// W3C trace context and baggage through HTTP headers. Spans are not recorded if endpoint is empty.
+func Configure(ctx context.Context, endpoint string, serviceName string) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if endpoint == "" {
+		return nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return err
+	}
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	))
+	return nil
+}
@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmcvetta/neoism"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type fakeConnection struct {
+	err error
+}
+
+func (c fakeConnection) CypherBatch(queries []*neoism.CypherQuery) error { return c.err }
+
+func recordSpans() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	return exporter
+}
+
+func TestStatementName(t *testing.T) {
+	assert.Equal(t, "create membership", StatementName(&neoism.CypherQuery{Statement: `
+		// create membership
+		MERGE (m:Membership {uuid: $uuid})`}))
+	assert.Equal(t, "MATCH", StatementName(&neoism.CypherQuery{Statement: "match (n) return n"}))
+	assert.Equal(t, "empty statement", StatementName(&neoism.CypherQuery{}))
+}
+
+func TestRunTracesTheTransactionAndEachStatement(t *testing.T) {
+	assert := assert.New(t)
+	exporter := recordSpans()
+	queries := []*neoism.CypherQuery{
+		{Statement: "// read membership\nMATCH (m) RETURN m", Parameters: map[string]interface{}{"uuid": "a"}},
+		{Statement: "RETURN 1"},
+	}
+
+	assert.NoError(Run(context.Background(), fakeConnection{}, true, queries))
+
+	spans := exporter.GetSpans()
+	assert.Len(spans, 3)
+	transaction := spans[2]
+	assert.Equal("neo4j read transaction", transaction.Name)
+	assert.Contains(transaction.Attributes, StatementCountKey.Int(2))
+	assert.Equal("read membership", spans[0].Name)
+	assert.Contains(spans[0].Attributes, ParameterCountKey.Int(1))
+	assert.Equal("RETURN", spans[1].Name)
+	for _, span := range spans[:2] {
+		assert.Equal(transaction.SpanContext.SpanID(), span.Parent.SpanID())
+	}
+}
+
+func TestRunRecordsErrors(t *testing.T) {
+	exporter := recordSpans()
+
+	err := Run(context.Background(), fakeConnection{errors.New("Neo.TransientError.Cluster.NotALeader")}, false, []*neoism.CypherQuery{{Statement: "RETURN 1"}})
+	assert.Error(t, err)
+
+	for _, span := range exporter.GetSpans() {
+		assert.Equal(t, codes.Error, span.Status.Code, span.Name)
+	}
+}